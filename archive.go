@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// archiveSeparator separates the name of an archive from the name of one of its
+// members, as in "bundle.tar.gz//logs/test.out".
+const archiveSeparator = "//"
+
+// splitArchivePath returns the name of an archive and a pattern matching the
+// names of its members when path refers to members of an archive, either
+// because --member was given, or because path is the name of a regular file
+// followed by archiveSeparator and a pattern. A path that names an existing
+// file never refers to members of an archive.
+func splitArchivePath(p string) (string, string, bool) {
+	if _, err := os.Stat(p); err != nil {
+		for i := strings.Index(p, archiveSeparator); i > 0; {
+			archive, pattern := p[:i], p[i+len(archiveSeparator):]
+			if fi, err := os.Stat(archive); err == nil && fi.Mode().IsRegular() && pattern != "" {
+				return archive, pattern, true
+			}
+			j := strings.Index(p[i+1:], archiveSeparator)
+			if j < 0 {
+				break
+			}
+			i += j + 1
+		}
+	}
+
+	if *optMember != "" {
+		return p, *optMember, true
+	}
+
+	return "", "", false
+}
+
+// selectsMembers returns true when the named files may refer to more than one
+// member of an archive.
+func selectsMembers(paths []string) bool {
+	if *optMember != "" {
+		return true
+	}
+	for _, p := range paths {
+		if _, pattern, ok := splitArchivePath(p); ok && strings.ContainsAny(pattern, `*?[\`) {
+			return true
+		}
+	}
+	return false
+}
+
+// withInputs invokes callback with the name and the decompressed contents of
+// the named file, or when the name refers to members of an archive, with the
+// name and decompressed contents of each matching member in turn. The name of a
+// member is the name of its archive, followed by archiveSeparator and the name
+// of the member. A file given along with --member that is not an archive is
+// read like any other file.
+func withInputs(p string, callback func(string, io.Reader) error) error {
+	archive, pattern, ok := splitArchivePath(p)
+	if !ok {
+		return withInput(p, func(r io.Reader) error {
+			return callback(displayName(p), r)
+		})
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("cannot match members: %s", err)
+	}
+
+	var matched bool
+	var notArchive func(io.Reader) error
+
+	if archive == p {
+		// Only --member refers to members without naming an archive.
+		notArchive = func(r io.Reader) error {
+			matched = true
+			return callback(displayName(p), r)
+		}
+	}
+
+	err := withOpenFile(archive, func(fh *os.File) error {
+		return withMembers(fh, notArchive, func(name string, r io.Reader) error {
+			if ok, _ := path.Match(pattern, strings.TrimPrefix(name, "./")); !ok {
+				return nil
+			}
+			matched = true
+			if *optDecompress == decompressAuto {
+				var err error
+				if r, err = decompress(r); err != nil {
+					return fmt.Errorf("cannot read %q: %s", name, err)
+				}
+			}
+			return callback(displayName(archive)+archiveSeparator+name, r)
+		})
+	})
+	if err == nil && !matched {
+		err = fmt.Errorf("no member matches %q", pattern)
+	}
+	return err
+}
+
+// withMembers invokes callback with the name and contents of each regular file
+// in the tar or zip archive fh, in the order they appear in the archive. A tar
+// archive may be compressed. When fh is not an archive, notArchive is invoked
+// with its decompressed contents instead, unless notArchive is nil.
+func withMembers(fh *os.File, notArchive func(io.Reader) error, callback func(string, io.Reader) error) error {
+	r, err := decompress(fh)
+	if err != nil {
+		return err
+	}
+
+	var block []byte
+
+	if r == io.Reader(fh) {
+		// A zip archive must be read from its end, so only a zip archive that
+		// is neither compressed nor read from a pipe is supported.
+		if fi, err := fh.Stat(); err == nil && fi.Mode().IsRegular() {
+			block = make([]byte, tarBlockSize)
+			n, _ := fh.ReadAt(block, 0)
+			if block = block[:n]; bytes.HasPrefix(block, []byte("PK\x03\x04")) || bytes.HasPrefix(block, []byte("PK\x05\x06")) {
+				return withZipMembers(fh, fi.Size(), callback)
+			}
+		}
+	}
+
+	if block == nil {
+		br := bufio.NewReader(r)
+		block, _ = br.Peek(tarBlockSize)
+		r = br
+	}
+
+	if notArchive != nil && !isTarHeader(block) {
+		return notArchive(r)
+	}
+
+	return withTarMembers(r, callback)
+}
+
+// tarBlockSize is the size in bytes of each block of a tar archive, including
+// the header of each member.
+const tarBlockSize = 512
+
+// isTarHeader returns true when block is the header of the first member of a
+// tar archive, as determined by its checksum, or the block of zero bytes that
+// ends an empty tar archive.
+func isTarHeader(block []byte) bool {
+	if len(block) < tarBlockSize {
+		return false
+	}
+
+	field := block[148:156] // checksum, in octal, terminated by a NUL or space
+	want, err := strconv.ParseInt(strings.Trim(string(field), " \x00"), 8, 64)
+	if err != nil {
+		return bytes.Count(block, []byte{0}) == tarBlockSize
+	}
+
+	// The checksum is the sum of the bytes of the header, with the bytes of
+	// the checksum field taken to be spaces. Some historic archives summed
+	// signed bytes.
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return want == unsigned || want == signed
+}
+
+// withZipMembers invokes callback with the name and contents of each regular
+// file in the zip archive fh, which is size bytes long.
+func withZipMembers(fh *os.File, size int64, callback func(string, io.Reader) error) error {
+	zr, err := zip.NewReader(fh, size)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		if !f.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("cannot read %q: %s", f.Name, err)
+		}
+		err = callback(f.Name, rc)
+		runningStages.Wait()
+		if err2 := rc.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withTarMembers invokes callback with the name and contents of each regular
+// file in the tar archive r.
+func withTarMembers(r io.Reader, callback func(string, io.Reader) error) error {
+	tr := tar.NewReader(r)
+
+	for first := true; ; first = false {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if err == tar.ErrHeader || (first && err == io.ErrUnexpectedEOF) {
+				err = errors.New("not a tar or zip archive")
+			}
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if err = callback(hdr.Name, tr); err != nil {
+			return err
+		}
+		runningStages.Wait() // before reading the following member
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsTarHeader(t *testing.T) {
+	archive := func(name string) []byte {
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 6}); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write([]byte("hello\n"))
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	empty := func() []byte {
+		var b bytes.Buffer
+		if err := tar.NewWriter(&b).Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	corrupt := archive("logs/test.out")
+	corrupt[0] = 'X'
+
+	cases := []struct {
+		name  string
+		block []byte
+		want  bool
+	}{
+		{"archive", archive("logs/test.out"), true},
+		{"archive with long name", archive(strings.Repeat("long/", 40) + "test.out"), true},
+		{"empty archive", empty(), true},
+		{"corrupt header", corrupt, false},
+		{"text", []byte(strings.Repeat("some text\n", 100)), false},
+		{"short text", []byte("some text\n"), false},
+		{"nothing", nil, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			block := tc.block
+			if len(block) > tarBlockSize {
+				block = block[:tarBlockSize]
+			}
+			if got := isTarHeader(block); got != tc.want {
+				t.Errorf("GOT: %v; WANT: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithInputsMemberPassesOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, name := range []string{"logs/test.out", "logs/other.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name) + 1)}); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write([]byte(name + "\n"))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(dir, "bundle.tar")
+	if err := os.WriteFile(bundle, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "a.log")
+	if err := os.WriteFile(plain, []byte("plain\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(member string) { *optMember = member }(*optMember)
+	*optMember = "logs/*.out"
+
+	var got []string
+	for _, p := range []string{plain, bundle} {
+		err := withInputs(p, func(name string, r io.Reader) error {
+			contents, err := io.ReadAll(r)
+			got = append(got, strings.TrimPrefix(name, dir+"/")+": "+string(contents))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("GOT: %v; WANT: %v", err, nil)
+		}
+	}
+
+	want := []string{"a.log: plain\n", "bundle.tar//logs/test.out: logs/test.out\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestWithInputsArchivePathRequiresArchive(t *testing.T) {
+	plain := filepath.Join(t.TempDir(), "a.log")
+	if err := os.WriteFile(plain, []byte("plain\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := withInputs(plain+archiveSeparator+"x", func(string, io.Reader) error { return nil })
+	if err == nil {
+		t.Errorf("GOT: %v; WANT: error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/karrick/golf"
+)
+
+// stdinArg replaces each command line argument that is a lone hyphen while the
+// command line is parsed, because golf rejects a lone hyphen, which this
+// program accepts as the name of standard input.
+const stdinArg = "\x00-"
+
+// parseFlags parses the command line flags, then returns the remaining command
+// line arguments.
+func parseFlags() ([]string, error) {
+	for i, arg := range os.Args {
+		if i == 0 {
+			continue
+		}
+		if arg == "-" {
+			os.Args[i] = stdinArg
+			continue
+		}
+		// golf does not accept a flag value joined to its flag by an equal
+		// sign, which is how tail accepts the value for --follow.
+		if value := strings.TrimPrefix(arg, "--follow="); value != arg {
+			switch value {
+			case "descriptor":
+				os.Args[i] = "--follow"
+			case "name":
+				os.Args[i] = "--follow-name"
+			default:
+				return nil, NewErrUsage("cannot follow by %q: expected either 'descriptor' or 'name'.", value)
+			}
+		}
+	}
+
+	golf.Parse()
+
+	// A lone hyphen may also have been given as the value of a flag.
+	for _, p := range []*string{optRange, optFrom, optTo, optSkipTop, optSkipBottom, optTop, optBottom, optMarker, optNumSep} {
+		if *p == stdinArg {
+			*p = "-"
+		}
+	}
+
+	args := golf.Args()
+	for i, arg := range args {
+		if arg == stdinArg {
+			args[i] = "-"
+		}
+	}
+	return args, nil
+}
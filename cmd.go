@@ -2,16 +2,13 @@ package main
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
 
-	"github.com/karrick/gobls"
 	"github.com/karrick/golf"
-	"github.com/karrick/gotb"
+	"github.com/karrick/lines/linesel"
 )
 
 func init() {
@@ -29,31 +26,80 @@ var (
 	optVerbose = golf.BoolP('v', "verbose", false, "Print verbose output to stderr.")
 	optForce   = golf.Bool("force", false, "Print error messages but continue processing.")
 
-	optRange      = golf.StringP('r', "range", "", "Only print lines START-END.")
-	optSkipTop    = golf.Uint("skip-top", 0, "Skip printing the top N header lines.")
-	optSkipBottom = golf.Uint("skip-bottom", 0, "Skip printing the bottom N footer lines.")
-	optTop        = golf.UintP('t', "top", 0, "Only print the top N lines.")
-	optBottom     = golf.UintP('b', "bottom", 0, "Only print the bottom N lines.")
+	optRange      = golf.StringP('r', "range", "", "Only print lines START-END or START:END, or a comma separated list of such ranges.")
+	optFrom       = golf.String("from", "", "Only print lines starting with the first line matching REGEX.")
+	optTo         = golf.String("to", "", "Only print lines ending with the next line matching REGEX.")
+	optExclusive  = golf.Bool("exclusive", false, "Do not print the lines matching the patterns that begin and end ranges.")
+	optRepeat     = golf.Bool("repeat", false, "Begin a pattern range again each time its initial pattern matches.")
+	optSkipTop    = golf.String("skip-top", "", "Skip printing the top N (or N%) header lines.")
+	optSkipBottom = golf.String("skip-bottom", "", "Skip printing the bottom N (or N%) footer lines.")
+	optTop        = golf.StringP('t', "top", "", "Only print the top N (or N%) lines.")
+	optBottom     = golf.StringP('b', "bottom", "", "Only print the bottom N (or N%) lines.")
+	optAround     = golf.Uint("around", 0, "Only print line N, marked, along with the lines of context around it.")
+	optContext    = golf.UintP('C', "context", 0, "Print N lines of context before and after the --around line.")
+	optAfter      = golf.UintP('A', "after", 0, "Print N lines of context after the --around line.")
+	optBefore     = golf.UintP('B', "before", 0, "Print N lines of context before the --around line.")
+	optMarker     = golf.String("marker", "> ", "Prefix for the --around line, with context lines indented to match.")
+	optNumber     = golf.BoolP('n', "number", false, "Prefix each printed line with its line number in the input.")
+	optNumWidth   = golf.Uint("number-width", 6, "Right align line numbers printed by --number to N columns.")
+	optNumSep     = golf.String("number-separator", "\t", "Separate line numbers printed by --number from their lines with STRING.")
+	optInvert     = golf.Bool("invert", false, "Print only the lines the other options would not print.")
+	optDelete     = golf.Bool("delete-range", false, "Same as --invert.")
+
+	optWithFilename = golf.BoolP('H', "with-filename", false, "Prefix each printed line with the name of its file.")
+	optNoFilename   = golf.Bool("no-filename", false, "Do not print a header before the lines of each file.")
+	optConcat       = golf.Bool("concat", false, "Process all files as a single concatenated input.")
+	optFollow       = golf.BoolP('f', "follow", false, "Print lines appended to the file as it grows.")
+	optFollowName   = golf.BoolP('F', "follow-name", false, "Same as --follow, but reopen the file when it is renamed or replaced.")
+	optMmap         = golf.String("mmap", "never", "Map regular files into memory: never, auto, or always.")
+
+	optMember        = golf.String("member", "", "Only print lines from members of archives whose names match GLOB.")
+	optDecompress    = golf.String("decompress", decompressAuto, "Decompress input: auto, none, gzip, bzip2, or zlib.")
+	optMaxLineLength = golf.Uint("max-line-length", 0, "Apply the --long-lines policy to lines longer than N bytes.")
+	optLongLines     = golf.String("long-lines", longLineError, "Policy for lines longer than --max-line-length: error, truncate, or split.")
 )
 
 func cmd() error {
-	golf.Parse()
+	args, err := parseFlags()
+	if err != nil {
+		return err
+	}
 
 	if *optHelp {
 		fmt.Println(golf.Wrap("SUMMARY:  lines [options] [file1 [file2]] [options]"))
 		fmt.Println(golf.Wrap("Without command line arguments, reads from standard input and writes to standard output. With command line arguments, reads from each file in sequence, and applies the below logic independently for each file."))
-		fmt.Println(golf.Wrap("When given the '--range N' command line argument, prints the line number corresponding to N. When given the '--range START-END' command line argument, prints lines 'START' thru 'END', inclusively. START must not be greater than the value of END. When START is omitted, the first line printed will be the first line of the input. When END is omitted, the final line printed will be the final line of the input."))
+		fmt.Println(golf.Wrap("When given the '--range N' command line argument, prints the line number corresponding to N. When given the '--range START-END' command line argument, prints lines 'START' thru 'END', inclusively. START must not be greater than the value of END. When START is omitted, the first line printed will be the first line of the input. When END is omitted, the final line printed will be the final line of the input. Multiple ranges may be given as a comma separated list, such as '--range 1-3,7,20-', in which case lines from each range are printed in a single pass, in the order they appear in the input, and lines covered by overlapping ranges are only printed once."))
+		fmt.Println(golf.Wrap("When given the '--range START:END' command line argument, prints lines 'START' thru 'END', inclusively, where a negative START or END counts lines from the end of the input, such that -1 is the final line, -2 is the line before the final line, and so on. For instance, '--range 5:-3' prints line 5 thru the third line from the end, and '--range -10:' prints the final 10 lines. Only as many lines as the largest negative value are held in memory."))
+		fmt.Println(golf.Wrap("Any range may be followed by '~STEP' to print only every STEP-th line of that range, beginning with its first line, so '--range 100-5000~10' prints lines 100, 110, 120, and so on thru 5000. The START:END:STEP form is equivalent to START:END~STEP. When the bounds are omitted, as in '--range ~10' or '--range ::10', every STEP-th line of the entire input is printed, beginning with the first line. 'N~STEP' prints every STEP-th line beginning with line N, thru the final line of the input."))
+		fmt.Println(golf.Wrap("Either address of a range may be a regular expression between slashes, such as '--range /BEGIN/-/END/', which begins with the first line matching BEGIN and ends with the following line matching END. Like sed, the end pattern is only checked starting with the line after the line that begins the range. The sed forms '/BEGIN/,/END/', '/BEGIN/,N', and '/BEGIN/,+N' are also accepted, where the latter prints the line matching BEGIN and the N lines following it. A range consisting of a single pattern, such as '--range /ERROR/', prints every line that matches. A slash may be included in a pattern by escaping it with a backslash."))
+		fmt.Println(golf.Wrap("When given the '--range START+COUNT' command line argument, prints COUNT lines beginning with line START, so '--range 1000+50' prints lines 1000 thru 1049. START may also be a pattern, as in '--range /ERROR/+5', which prints the first line matching ERROR and the 4 lines following it. Note this differs from the sed form '/ERROR/,+5', which prints the matching line and the 5 lines following it."))
+		fmt.Println(golf.Wrap("When given the '--from REGEX' command line argument, prints lines starting with the first line matching REGEX, and when given the '--to REGEX' command line argument, prints lines ending with the next line matching REGEX, which may be combined, and may be used with '--range'. By default pattern ranges include the lines that match their patterns and only print the first matching range. When given the '--exclusive' command line argument, the lines matching the patterns are omitted, and when given the '--repeat' command line argument, a range begins again each time its initial pattern matches after the range ends."))
+		fmt.Println(golf.Wrap("Line numbers in ranges, and the number of lines given to '--skip-top', '--skip-bottom', '--top', and '--bottom', may be given as a percentage of the lines in the input, such as '--range 40%-60%', '--skip-top 10%', or '--bottom 5%'. Percentages are always rounded down to a whole number of lines. A percentage START refers to the line following the initial START percent of the lines, and a percentage END refers to the final line of the initial END percent of the lines, so for 10 lines of input, '--range 40%-60%' prints lines 5 and 6, and '--bottom 5%' prints nothing. Regular files are counted by reading them before the lines are printed, while standard input is spooled to a temporary file while counted."))
+		fmt.Println(golf.Wrap("When given the '--around N' command line argument, prints line N prefixed with a marker, along with the lines of context around it, each prefixed with spaces as wide as the marker, which is handy when a compiler or stack trace refers to a particular line. The '--context N' or '-C N' command line argument sets the number of lines printed both before and after line N, while '--before N' or '-B N', and '--after N' or '-A N', set them independently, overriding '--context' when not zero. The marker defaults to '> ', and may be changed with '--marker STRING'."))
 		fmt.Println(golf.Wrap("When given the '--skip-top N' command line argument, omits printing the initial N lines, handy for removing a possibly multiline header from some text."))
 		fmt.Println(golf.Wrap("When given the '--skip-bottom N' command line argument, omits printing the final N lines, handy for removing a possibly multiline footer from some text."))
 		fmt.Println(golf.Wrap("When given the '--top N' command line argument, prints only the initial N lines, similar to the behavior of 'head -n N', but included in this tool for completeness."))
-		fmt.Println(golf.Wrap("When given the '--bottom N' command line argument, prints only the final N lines, similar to the behavior of 'tail -n N', but included in this tool for completeness."))
-		fmt.Println(golf.Wrap("USAGE:    Not all options may be used with all other options. See below synopsis for reference."))
-
-		fmt.Println("\tlines [\t--top N | --bottom N |\n\t\t" + strings.Join([]string{
-			"\t--range M-N | --range M- | --range -N | --range N |",
-			"\t--skip-top N | --skip-bottom N ]",
+		fmt.Println(golf.Wrap("When given the '--bottom N' command line argument, prints only the final N lines, similar to the behavior of 'tail -n N', but included in this tool for completeness. When reading a regular file, '--bottom' and '--skip-bottom' find the final N lines by reading backwards from the end of the file, so '--bottom' need not read the lines before them unless '--number' requires their line numbers."))
+		fmt.Println(golf.Wrap("When given the '--number' or '-n' command line argument, prefixes each printed line with the line number it had in the input, rather than its position in the output, right aligned to the number of columns given by '--number-width N', which defaults to 6, and separated from the line by the string given by '--number-separator STRING', which defaults to a tab character."))
+		fmt.Println(golf.Wrap("When given the '--follow' or '-f' command line argument, after printing the selected lines of a file, waits for lines to be appended to the file and prints them as they arrive, similar to the behavior of 'tail -f'. Appended lines continue to be subject to '--skip-top' and '--range', and continue to be numbered by '--number'. A final line not yet terminated by a newline is not printed until its newline is appended. When given the '--follow=name' or '-F' command line argument, the file is followed by name rather than by its descriptor, similar to the behavior of 'tail -F', so when the file is renamed and replaced by a new file, as is common when log files are rotated, the new file is opened and its lines are printed from its start. A file that is truncated is printed from its start in either case. Line numbers restart at 1 with each new or truncated file. Only a single file may be followed, and '--follow' may not be combined with '--top', '--skip-bottom', '--invert', or a range addressed by a pattern, a percentage, or relative to the end of the input."))
+		fmt.Println(golf.Wrap("When given the '--invert' command line argument, or its alias '--delete-range', the range, top, and bottom stages described below print only the lines they would not otherwise print. For instance, '--invert --range 3-5' prints every line except lines 3 thru 5, '--invert --top N' is equivalent to '--skip-top N', and '--invert --bottom N' is equivalent to '--skip-bottom N'. When none of those stages are used, '--invert --skip-top M --skip-bottom N' prints only the top M and bottom N lines."))
+		fmt.Println(golf.Wrap("Input compressed with gzip, bzip2, or zlib is decompressed, as determined by its initial bytes, including gzip files with multiple members, such as those formed by concatenating gzip files. The '--decompress FORMAT' command line argument, where FORMAT is gzip, bzip2, or zlib, decompresses every input using that format, while '--decompress none' never decompresses input. The default is '--decompress auto'. A file that cannot be decompressed is reported like any other file that cannot be read. Compressed files may not be followed."))
+		fmt.Println(golf.Wrap("Lines may be printed from the regular files within tar and zip archives, where a tar archive may be compressed, by following the name of the archive with two slashes and the name of a member, such as 'bundle.tar.gz//logs/test.out'. The name of the member may be a glob pattern, such as 'bundle.zip//logs/*.out', in which case the lines of each matching member are printed in turn, as though each were a separate file. The '--member GLOB' command line argument selects the members matching GLOB from every archive given on the command line, while any other file given along with it is read as usual. The name of each member, following the name of its archive and two slashes, is printed in headers and by '--with-filename'. Members are decompressed like any other file."))
+		fmt.Println(golf.Wrap("Lines of any length are accepted by default. When given the '--max-line-length N' command line argument, lines longer than N bytes are handled according to the '--long-lines POLICY' command line argument, identically in every mode: 'error', the default, stops reading the input with an error, 'truncate' discards the bytes beyond the initial N bytes, and 'split' treats each N bytes of the line as a separate line, which is numbered and counted as such. Lines are truncated or split at byte boundaries, even within a multi-byte character. Because every line must be examined, '--max-line-length' prevents reading a file backwards from its end, or using its index."))
+		fmt.Println(golf.Wrap("Files are read by default. When given the '--mmap auto' command line argument, regular files of at least 1 MiB are instead mapped into memory and scanned in place, which may be slightly faster than reading them, and '--mmap always' maps regular files of any size into memory. A file that is truncated while it is mapped into memory causes the program to crash, so files that may be truncated while they are being printed, such as log files rotated by copying and truncating them, must not be mapped into memory. The default is '--mmap never'. Mapping files into memory is not supported on every platform, in which case they are always read."))
+		fmt.Println(golf.Wrap("When invoked as 'lines index FILE...', writes an index for each file to a file with the same name followed by '.lines-index', recording the byte offset of every 4096th line. When printing a range of a regular file that has an index, the lines before the range are skipped by seeking directly to the indexed line preceding the range, rather than by reading them. An index that no longer matches the size and modification time of its file is ignored, and may be rebuilt by running 'lines index FILE' again. To print the lines of a file named 'index', refer to it as './index'."))
+		fmt.Println(golf.Wrap("USAGE:    Options may be combined freely. Each option adds a stage to a pipeline, and each stage operates on the lines printed by the preceding stage, always in the following order, regardless of the order the options are given: skip the top and bottom lines, then print only the range, then print only the top lines, then print only the bottom lines. For instance, '--skip-top 1 --top 10' prints the 10 lines following a single line header, and '--range 100- --bottom 5' prints the final 5 lines when the input has at least 104 lines."))
+
+		fmt.Println("\tlines [--skip-top N] [--skip-bottom N]\n\t\t" + strings.Join([]string{
+			"\t[--range M-N | --range M- | --range -N | --range N | --range M:-N | --range M-N~S | --range M-N,P,Q- |",
+			"\t--range M+N | --range /RE1/,/RE2/ | --range /RE/,+N] [--from RE1] [--to RE2] [--exclusive] [--repeat]",
+			"\t[--around N [-C N | -B N | -A N] [--marker STRING]]",
+			"\t[--top N] [--bottom N] [--invert] [--number [--number-width N] [--number-separator STRING]]",
+			"\t[--member GLOB] [--with-filename | --no-filename | --concat] [--follow | --follow=name] [--mmap never|auto|always]",
+			"\t[--max-line-length N [--long-lines error|truncate|split]] [--decompress auto|none|gzip|bzip2|zlib]",
 			"\t[file1 [file2...]]",
-		}, "\n\t\t") + "\n")
+			"\twhere N, M, and any line number in a range may be a percentage, such as 10%",
+		}, "\n\t\t") + "\n\n\tlines index file1 [file2...]\n")
 		fmt.Println("EXAMPLES:")
 		fmt.Println("\tlines < sample.txt")
 		fmt.Println("\tlines sample.txt")
@@ -61,11 +107,39 @@ func cmd() error {
 		fmt.Println("\tlines sample.txt --range -3")
 		fmt.Println("\tlines sample.txt --range 7-")
 		fmt.Println("\tlines sample.txt --range 3")
+		fmt.Println("\tlines sample.txt --range 1-2,5,8-")
+		fmt.Println("\tlines sample.txt --range 5:-3")
+		fmt.Println("\tlines sample.txt --range -4:-2")
+		fmt.Println("\tlines sample.txt --range 2-8~3")
+		fmt.Println("\tlines sample.txt --range ::2")
+		fmt.Println("\tlines sample.txt --range '/^3:/,/^6:/'")
+		fmt.Println("\tlines sample.txt --range '/^8:/,+1'")
+		fmt.Println("\tlines sample.txt --range 4+3")
+		fmt.Println("\tlines sample.txt --range '/^8:/+2'")
+		fmt.Println("\tlines sample.txt --from '^4:' --to '^7:' --exclusive")
+		fmt.Println("\tlines sample.txt --range 40%-60%")
+		fmt.Println("\tlines sample.txt --skip-top 20% --bottom 50%")
+		fmt.Println("\tlines sample.txt --around 5 -C 2")
+		fmt.Println("\tlines sample.txt --around 5 -B 1 -A 3")
 		fmt.Println("\tlines sample.txt --skip-top 2")
 		fmt.Println("\tlines sample.txt --skip-bottom 2")
 		fmt.Println("\tlines sample.txt --skip-top 3 --skip-bottom 2")
 		fmt.Println("\tlines sample.txt --top 3")
 		fmt.Println("\tlines sample.txt --bottom 3")
+		fmt.Println("\tlines sample.txt --invert --range 3-8")
+		fmt.Println("\tlines sample.txt --invert --skip-top 2 --skip-bottom 2")
+		fmt.Println("\tlines sample.txt --skip-top 1 --top 3")
+		fmt.Println("\tlines sample.txt --number --range 2-8~3 --bottom 2")
+		fmt.Println("\tlines sample.txt --range 2- --skip-bottom 1 --bottom 3")
+		fmt.Println("\tlines --top 2 sample.txt sample.txt")
+		fmt.Println("\tlines --with-filename --number --range 4 sample.txt sample.txt")
+		fmt.Println("\tlines --concat --number --range 9-12 sample.txt sample.txt")
+		fmt.Println("\tlines --bottom 20 /var/log/system.log.1.gz")
+		fmt.Println("\tlines --bottom 50 bundle.tar.gz//logs/test.out")
+		fmt.Println("\tlines --top 5 --member 'logs/*.out' bundle.zip")
+		fmt.Println("\tlines --follow --bottom 10 /var/log/system.log")
+		fmt.Println("\tlines --follow=name --bottom 10 /var/log/system.log")
+		fmt.Println("\tlines index huge.log && lines huge.log --range 5000000-5000100")
 		fmt.Println("\nCommand line options:")
 		golf.PrintDefaults()
 		return nil
@@ -80,106 +154,254 @@ func cmd() error {
 		}
 	}
 
-	if *optTop != 0 {
-		if *optBottom != 0 {
-			return NewErrUsage("cannot print only the top, and only the bottom.")
-		}
-		if *optRange != "" {
-			return NewErrUsage("cannot print only the top, and only a range.")
-		}
-		if *optSkipBottom != 0 {
-			return NewErrUsage("cannot print only the top, and skip the bottom.")
-		}
-		if *optSkipTop != 0 {
-			return NewErrUsage("cannot print only the top, and skip the top.")
-		}
-		return (filter(golf.Args(), func(r io.Reader, w io.Writer) error {
-			return top(int(*optTop), r, w)
-		}))
+	switch *optMmap {
+	case "never", "auto", "always":
+	default:
+		return NewErrUsage("cannot parse --mmap: expected never, auto, or always: %q.", *optMmap)
 	}
 
-	if *optBottom != 0 {
-		if *optRange != "" {
-			return NewErrUsage("cannot print only the bottom, and only a range.")
-		}
-		if *optSkipBottom != 0 {
-			return NewErrUsage("cannot print only the bottom, and skip the bottom.")
-		}
-		if *optSkipTop != 0 {
-			return NewErrUsage("cannot print only the bottom, and skip the top.")
-		}
-		return (filter(golf.Args(), func(r io.Reader, w io.Writer) error {
-			return bottom(int(*optBottom), r, w)
+	switch *optDecompress {
+	case decompressAuto, decompressNone, decompressGzip, decompressBzip2, decompressZlib:
+	default:
+		return NewErrUsage("cannot parse --decompress: expected auto, none, gzip, bzip2, or zlib: %q.", *optDecompress)
+	}
+
+	switch *optLongLines {
+	case longLineError, longLineTruncate, longLineSplit:
+	default:
+		return NewErrUsage("cannot parse --long-lines: expected error, truncate, or split: %q.", *optLongLines)
+	}
+
+	if len(args) > 0 && args[0] == "index" {
+		return indexFiles(args[1:])
+	}
+
+	if *optWithFilename && *optNoFilename {
+		return NewErrUsage("cannot use both --with-filename and --no-filename")
+	}
+	if *optWithFilename && *optConcat {
+		return NewErrUsage("cannot use both --with-filename and --concat")
+	}
+	if *optMember != "" && (*optConcat || *optFollow || *optFollowName) {
+		return NewErrUsage("cannot use --member with --concat or --follow")
+	}
+
+	invert := *optInvert || *optDelete
+
+	skipTop, err := parseAmount("--skip-top", *optSkipTop)
+	if err != nil {
+		return err
+	}
+	skipBottom, err := parseAmount("--skip-bottom", *optSkipBottom)
+	if err != nil {
+		return err
+	}
+	topLines, err := parseAmount("--top", *optTop)
+	if err != nil {
+		return err
+	}
+	bottomLines, err := parseAmount("--bottom", *optBottom)
+	if err != nil {
+		return err
+	}
+
+	// Build the pipeline in the order documented by the help text.
+	var stages []stage
+
+	if !skipTop.IsZero() || !skipBottom.IsZero() {
+		stages = append(stages, countedStage(skipTop.IsPercent() || skipBottom.IsPercent(), func(r io.Reader, w io.Writer, total int) error {
+			return selectLines(r, w, linesel.Skip{Initial: skipTop.Lines(total), Final: skipBottom.Lines(total)})
 		}))
 	}
 
-	if *optRange != "" {
-		if *optSkipBottom != 0 {
-			return NewErrUsage("cannot print only a range, and skip the bottom.")
+	if *optAround == 0 && (*optContext != 0 || *optAfter != 0 || *optBefore != 0) {
+		return NewErrUsage("cannot print lines of context without --around.")
+	}
+
+	hasRange := *optRange != "" || *optFrom != "" || *optTo != "" || *optAround != 0
+	var ranges linesel.Ranges
+
+	if hasRange {
+		if *optRange != "" {
+			if ranges, err = linesel.ParseRanges(*optRange); err != nil {
+				return NewErrUsage("%s", err)
+			}
 		}
-		if *optSkipTop != 0 {
-			return NewErrUsage("cannot print only a range, and skip the top.")
+
+		if *optFrom != "" || *optTo != "" {
+			rs, err := linesel.PatternRange(*optFrom, *optTo)
+			if err != nil {
+				return NewErrUsage("%s", err)
+			}
+			ranges = ranges.Union(rs)
 		}
 
-		var initialLine, finalLine int
-		var err error
+		ranges = ranges.WithPatternOptions(*optExclusive, *optRepeat)
 
-		switch lines := strings.Split(*optRange, "-"); len(lines) {
-		case 1:
-			if a := lines[0]; a != "" {
-				initialLine, err = strconv.Atoi(a)
-				if err != nil {
-					return NewErrUsage("cannot parse initial value from range: %q.", a)
-				}
-				finalLine = initialLine // when given a single number for a range, only print that line number
+		if *optAround != 0 {
+			before, after := *optBefore, *optAfter
+			if before == 0 {
+				before = *optContext
 			}
-		case 2:
-			if a := lines[0]; a != "" {
-				initialLine, err = strconv.Atoi(a)
-				if err != nil {
-					return NewErrUsage("cannot parse initial value from range: %q.", a)
-				}
+			if after == 0 {
+				after = *optContext
 			}
+			rs, err := linesel.AroundRange(int(*optAround), int(before), int(after), *optMarker)
+			if err != nil {
+				return NewErrUsage("%s", err)
+			}
+			ranges = ranges.Union(rs)
+		}
 
-			if a := lines[1]; a != "" {
-				finalLine, err = strconv.Atoi(a)
-				if err != nil {
-					return NewErrUsage("cannot parse final value from range: %q.", a)
-				}
+		stages = append(stages, countedStage(ranges.HasPercent(), func(r io.Reader, w io.Writer, total int) error {
+			rs := ranges.Resolve(total)
+			if invert {
+				rs = rs.Invert()
 			}
+			return selectLines(r, w, rs)
+		}))
+	}
 
-			if finalLine > 0 && initialLine > finalLine {
-				return NewErrUsage("cannot print lines %d thru %d because they are out of order.", initialLine, finalLine)
+	if !topLines.IsZero() {
+		stages = append(stages, countedStage(topLines.IsPercent(), func(r io.Reader, w io.Writer, total int) error {
+			var sel linesel.Selector = linesel.Top(topLines.Lines(total))
+			if invert {
+				sel = linesel.Invert(sel)
 			}
+			return selectLines(r, w, sel)
+		}))
+	}
 
-		default:
-			return NewErrUsage("cannot print invalid range of lines: %q.", *optRange)
+	if !bottomLines.IsZero() {
+		stages = append(stages, countedStage(bottomLines.IsPercent(), func(r io.Reader, w io.Writer, total int) error {
+			var sel linesel.Selector = linesel.Bottom(bottomLines.Lines(total))
+			if invert {
+				sel = linesel.Invert(sel)
+			}
+			return selectLines(r, w, sel)
+		}))
+	}
+
+	if invert && !hasRange && topLines.IsZero() && bottomLines.IsZero() {
+		// Complement of skipping the top M and bottom N lines is printing only
+		// the top M and bottom N lines.
+		if skipTop.IsZero() && skipBottom.IsZero() {
+			return NewErrUsage("cannot invert without selecting lines to print.")
 		}
+		stages = []stage{countedStage(skipTop.IsPercent() || skipBottom.IsPercent(), func(r io.Reader, w io.Writer, total int) error {
+			return selectLines(r, w, linesel.Invert(linesel.Skip{Initial: skipTop.Lines(total), Final: skipBottom.Lines(total)}))
+		})}
+	}
 
-		return (filter(golf.Args(), func(r io.Reader, w io.Writer) error {
-			return copyRange(r, w, initialLine, finalLine)
-		}))
+	if len(stages) == 0 {
+		stages = append(stages, func(r io.Reader, w io.Writer) error {
+			return selectLines(r, w, linesel.Skip{})
+		})
+	}
+
+	var follow followFilter
+
+	if *optFollow || *optFollowName {
+		switch {
+		case len(args) > 1 || *optConcat:
+			return NewErrUsage("cannot follow more than one file.")
+		case !topLines.IsZero():
+			return NewErrUsage("cannot use both --follow and --top.")
+		case !skipBottom.IsZero():
+			return NewErrUsage("cannot use both --follow and --skip-bottom.")
+		case invert:
+			return NewErrUsage("cannot use both --follow and --invert.")
+		case !ranges.IsAbsolute():
+			return NewErrUsage("cannot follow a range addressed by a pattern, a percentage, or relative to the end of the input.")
+		}
+
+		follow = newFollowFilter(skipTop, ranges, hasRange)
 	}
 
-	return (filter(golf.Args(), func(r io.Reader, w io.Writer) error {
-		return skipRange(r, w, *optSkipTop, *optSkipBottom)
-	}))
+	return filter(os.Stdout, args, pipeline(stages...), follow)
 }
 
-func filter(args []string, callback func(io.Reader, io.Writer) error) error {
+// filter invokes callback for each named file, or for standard input when no
+// files are named, writing the lines it prints to w. When follow is not nil,
+// after callback returns, lines appended to the file are printed as they arrive
+// when follow returns true.
+func filter(w io.Writer, args []string, callback stage, follow followFilter) (err error) {
+	// Rather than writing each line to w, collect lines in a buffer that is
+	// written when full, and before returning.
+	out := bufio.NewWriter(w)
+
+	defer func() {
+		if err2 := out.Flush(); err == nil {
+			err = err2
+		}
+	}()
+
+	// output returns the writer for lines read from the named input.
+	output := func(name string) io.Writer {
+		if !*optNumber && !*optWithFilename {
+			return out
+		}
+		ow := outputWriter{Writer: out, number: *optNumber, width: int(*optNumWidth), separator: *optNumSep}
+		if *optWithFilename {
+			ow.filename = name
+		}
+		return ow
+	}
+
+	if follow != nil {
+		if len(args) == 0 {
+			return followFile(os.Stdin, "", output(displayName("-")), callback, follow)
+		}
+		var name string
+		if *optFollowName && args[0] != "-" {
+			name = args[0]
+		}
+		return withOpenFile(args[0], func(fh *os.File) error {
+			return followFile(fh, name, output(displayName(args[0])), callback, follow)
+		})
+	}
+
 	if len(args) == 0 {
-		return callback(os.Stdin, os.Stdout)
+		return withInput("-", func(r io.Reader) error {
+			return callback(r, output(displayName("-")))
+		})
 	}
 
+	if *optConcat {
+		cr := newConcatReader(args, out)
+		err = callback(cr, output(""))
+		runningStages.Wait()
+		if err2 := cr.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}
+
+	// Like head, print a header before the lines of each file when there is
+	// more than one file, unless each line is already prefixed by its file.
+	headers := (len(args) > 1 || selectsMembers(args)) && !*optWithFilename && !*optNoFilename
+	var printed bool
+
 	for _, arg := range args {
-		err := withOpenFile(arg, func(fh *os.File) error {
-			return callback(fh, os.Stdout)
+		err := withInputs(arg, func(name string, r io.Reader) error {
+			if headers {
+				if printed {
+					_ = out.WriteByte('\n')
+				}
+				_, _ = fmt.Fprintf(out, "==> %s <==\n", name)
+				printed = true
+			}
+			return callback(r, output(name))
 		})
 		if err != nil {
 			err = fmt.Errorf("cannot read %q: %s", arg, err)
 			if !*optForce {
 				return err
 			}
+			// Keep the warning in order with the lines printed before it.
+			if err2 := out.Flush(); err2 != nil {
+				return err2
+			}
 			warning("%s\n", err)
 		}
 	}
@@ -187,7 +409,22 @@ func filter(args []string, callback func(io.Reader, io.Writer) error) error {
 	return nil
 }
 
+// displayName returns the name used to refer to the named file in headers and
+// line prefixes.
+func displayName(path string) string {
+	if path == "-" {
+		return "(standard input)"
+	}
+	return path
+}
+
+// withOpenFile invokes callback with the named file opened for reading, then
+// closes it. The name "-" refers to standard input, which is not closed.
 func withOpenFile(path string, callback func(*os.File) error) (err error) {
+	if path == "-" {
+		return callback(os.Stdin)
+	}
+
 	var fh *os.File
 
 	fh, err = os.Open(path)
@@ -206,117 +443,52 @@ func withOpenFile(path string, callback func(*os.File) error) (err error) {
 	return
 }
 
-// copyRange will copy lines from r to w, starting with the line number
-// corresponding to start and ending with the line number corresponding to end.
-func copyRange(r io.Reader, w io.Writer, start, end int) error {
-	var lineNumber int
-
-	br := gobls.NewScanner(r)
-
-	for br.Scan() {
-		lineNumber++
-
-		if start > 0 && lineNumber < start {
-			continue
-		}
-
-		if _, err := fmt.Fprintln(w, br.Text()); err != nil {
-			return err
-		}
-
-		if end > 0 && lineNumber == end {
-			return nil
-		}
-	}
-
-	return br.Err()
-}
-
-// skipRange will copy lines from r to w, skipping the specified number of
-// initial and final lines.
-func skipRange(r io.Reader, w io.Writer, initial, final uint) error {
-	// Use a cirular buffer, so we are processing the Nth previous line.
-	cb, err := gotb.NewStrings(int(final))
-	if err != nil {
-		return err
-	}
-
-	var lineNumber uint // used to skip T lines from top
-
-	br := gobls.NewScanner(r)
-
-	for br.Scan() {
-		if initial > 0 {
-			// Only need to count lines while ignoring tops.
-			if lineNumber++; lineNumber <= initial {
-				continue
+// selectLines copies the lines sel selects from r to w. When r is a regular
+// file, lines that need not be read are skipped without reading them: the
+// final lines skipped by linesel.Skip and the lines preceding those printed by
+// linesel.Bottom are found by reading backwards from the end of the file, and
+// the lines preceding those printed by linesel.Ranges are skipped using the
+// index of the file, if it has one.
+func selectLines(r io.Reader, w io.Writer, sel linesel.Selector) error {
+	var skipped int
+
+	switch s := sel.(type) {
+	case linesel.Skip:
+		if s.Final > 0 {
+			head, _, err := splitFinalLines(r, s.Final)
+			if err != nil {
+				return err
+			}
+			if head != nil {
+				r, s.Final = head, 0
+				sel = s
 			}
-			// No reason to count lines any longer.
-			initial = 0
-		}
-
-		// Recall that the circular buffer always gives us the Nth previous
-		// line. When fewer than N lines have been queued, the second return
-		// value will be false.
-		line, ok := cb.QueueDequeue(br.Text())
-		if !ok {
-			continue
-		}
-
-		if _, err = fmt.Fprintln(w, line); err != nil {
-			return err
 		}
-	}
-
-	return br.Err()
-}
-
-// top copies the initial num lines from io.Reader to io.Writer.
-func top(num int, r io.Reader, w io.Writer) error {
-	if num == 0 {
-		return errors.New("cannot print the initial 0 lines.")
-	}
-
-	br := gobls.NewScanner(r)
-
-	for br.Scan() {
-		if _, err := fmt.Fprintln(w, br.Text()); err != nil {
-			return err
+	case linesel.Bottom:
+		// Line numbers of the final lines are only known after reading the
+		// lines before them.
+		if s > 0 && !wantsLineNumbers(w) {
+			_, tail, err := splitFinalLines(r, int(s))
+			if err != nil {
+				return err
+			}
+			if tail != nil {
+				r, sel = tail, linesel.Skip{}
+			}
 		}
-		if num--; num == 0 {
-			return nil
+	case linesel.Ranges:
+		if first := s.First(); first > 1 {
+			var err error
+			if r, skipped, err = seekIndexed(r, first); err != nil {
+				return err
+			}
+			sel = s.Seek(skipped)
 		}
 	}
 
-	return br.Err()
-}
-
-// bottom copies the final num lines from io.Reader to io.Writer.
-func bottom(num int, r io.Reader, w io.Writer) error {
-	if num == 0 {
-		return errors.New("cannot print the final 0 lines.")
-	}
-
-	cb, err := gotb.NewStrings(num)
-	if err != nil {
-		return err
-	}
-
-	scanner := bufio.NewScanner(r)
+	br := scanLines(r, w)
+	defer br.Close()
+	br.count = skipped
 
-	for scanner.Scan() {
-		_, _ = cb.QueueDequeue(scanner.Text())
-	}
-
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-
-	for _, line := range cb.Drain() {
-		if _, err = fmt.Fprintln(w, line); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return linesel.Select(w, br, sel)
 }
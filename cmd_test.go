@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/karrick/lines/linesel"
+)
+
+// setOption sets the command line option p to v until the test completes.
+func setOption[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	prev := *p
+	*p = v
+	t.Cleanup(func() { *p = prev })
+}
+
+// writeFiles writes each of the named files, with its contents, to a temporary
+// directory, and returns the name of the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runFilter returns what filter prints when invoked with args and callback,
+// along with what it prints to standard error, and the error it returns.
+func runFilter(t *testing.T, args []string, callback stage) (string, string, error) {
+	t.Helper()
+
+	stderrFile, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderrFile.Close()
+	setOption(t, &os.Stderr, stderrFile)
+
+	var out bytes.Buffer
+	err = filter(&out, args, callback, nil)
+	runningStages.Wait()
+
+	warnings, err2 := os.ReadFile(stderrFile.Name())
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	return out.String(), string(warnings), err
+}
+
+func TestFilterHeaders(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a": "a1\na2\na3\n",
+		"b": "b1\nb2\n",
+	})
+	a, b, missing := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "missing")
+
+	cases := []struct {
+		name     string
+		args     []string
+		with     bool // --with-filename
+		without  bool // --no-filename
+		force    bool
+		want     string
+		warnings string // prefix of the expected warning
+	}{
+		{
+			name: "single file",
+			args: []string{a},
+			want: "a1\na2\n",
+		},
+		{
+			name: "multiple files",
+			args: []string{a, b},
+			want: "==> " + a + " <==\na1\na2\n\n==> " + b + " <==\nb1\nb2\n",
+		},
+		{
+			name: "with filename",
+			args: []string{a, b},
+			with: true,
+			want: a + ":a1\n" + a + ":a2\n" + b + ":b1\n" + b + ":b2\n",
+		},
+		{
+			name:    "no filename",
+			args:    []string{a, b},
+			without: true,
+			want:    "a1\na2\nb1\nb2\n",
+		},
+		{
+			name:     "forced past failed file",
+			args:     []string{a, missing, b},
+			force:    true,
+			want:     "==> " + a + " <==\na1\na2\n\n==> " + b + " <==\nb1\nb2\n",
+			warnings: ProgramName + ": cannot read \"" + missing + "\"",
+		},
+		{
+			name:     "forced past failed first file",
+			args:     []string{missing, b},
+			force:    true,
+			want:     "==> " + b + " <==\nb1\nb2\n",
+			warnings: ProgramName + ": cannot read \"" + missing + "\"",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setOption(t, optWithFilename, tc.with)
+			setOption(t, optNoFilename, tc.without)
+			setOption(t, optForce, tc.force)
+
+			got, warnings, err := runFilter(t, tc.args, selectStage(linesel.Top(2)))
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+			if !strings.HasPrefix(warnings, tc.warnings) || (tc.warnings == "") != (warnings == "") {
+				t.Errorf("GOT: %q; WANT: %q", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+func TestFilterStopsAtFailedFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a": "a1\n", "b": "b1\n"})
+	args := []string{filepath.Join(dir, "a"), filepath.Join(dir, "missing"), filepath.Join(dir, "b")}
+
+	got, _, err := runFilter(t, args, selectStage(linesel.Skip{}))
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot read \""+args[1]+"\"") {
+		t.Errorf("GOT: %v; WANT: cannot read %q", err, args[1])
+	}
+	if want := "==> " + args[0] + " <==\na1\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestFilterStandardInput(t *testing.T) {
+	stdin, err := os.Open(filepath.Join(writeFiles(t, map[string]string{"in": "1\n2\n3\n"}), "in"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	setOption(t, &os.Stdin, stdin)
+	setOption(t, optWithFilename, true)
+
+	got, _, err := runFilter(t, nil, selectStage(linesel.Bottom(1)))
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if want := "(standard input):3\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// concatReader is an io.Reader that reads each of the named files in turn, as
+// though they were a single file, decompressing each as needed. A newline is
+// provided after the final line of any file that does not end with one, so that
+// the final line of one file is never joined with the initial line of the
+// following file. The name "-" refers to standard input.
+type concatReader struct {
+	names   []string
+	out     *bufio.Writer // flushed before printing a warning
+	current *os.File
+	reader  io.Reader // decompressed contents of current file
+	partial bool      // true when final byte read from current file was not a newline
+	err     error     // when not nil, returned after the current file is read
+}
+
+// newConcatReader returns a concatReader that reads each of the named files in
+// turn, where out buffers the lines printed from them.
+func newConcatReader(names []string, out *bufio.Writer) *concatReader {
+	return &concatReader{names: names, out: out}
+}
+
+func (cr *concatReader) Read(p []byte) (int, error) {
+	for {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+
+		if cr.current == nil {
+			if len(cr.names) == 0 {
+				return 0, io.EOF
+			}
+			if err := cr.open(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		n, err := cr.reader.Read(p)
+		if n > 0 {
+			cr.partial = p[n-1] != '\n'
+			return n, nil
+		}
+		if err == io.EOF {
+			if err = cr.close(); err != nil {
+				return 0, err
+			}
+			if cr.partial && len(p) > 0 {
+				cr.partial = false
+				p[0] = '\n'
+				return 1, nil
+			}
+			continue
+		}
+		if err != nil {
+			name := cr.names[0]
+			if err = cr.skip(fmt.Errorf("cannot read %q: %s", name, err)); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// open opens the next file. When the file cannot be opened, the error is
+// returned, unless --force was given, in which case a warning is printed and
+// the file is skipped.
+func (cr *concatReader) open() error {
+	name := cr.names[0]
+	fh := os.Stdin
+	if name != "-" {
+		var err error
+		if fh, err = os.Open(name); err != nil {
+			cr.names = cr.names[1:]
+			return cr.skip(fmt.Errorf("cannot read %q: %s", name, err))
+		}
+	}
+	cr.current = fh
+	r, err := decompress(fh)
+	if err != nil {
+		return cr.skip(fmt.Errorf("cannot read %q: %s", name, err))
+	}
+	cr.reader = r
+	return nil
+}
+
+// close closes the current file, which has been completely read.
+func (cr *concatReader) close() error {
+	fh := cr.current
+	cr.current, cr.reader = nil, nil
+	cr.names = cr.names[1:]
+	if fh == os.Stdin {
+		return nil
+	}
+	return fh.Close()
+}
+
+// skip either returns err, or when --force was given, prints err as a warning
+// and abandons the current file.
+func (cr *concatReader) skip(err error) error {
+	if !*optForce {
+		cr.err = err
+		return err
+	}
+	// Keep the warning in order with the lines printed before it.
+	if err2 := cr.out.Flush(); err2 != nil {
+		cr.err = err2
+		return err2
+	}
+	warning("%s\n", err)
+	if cr.current != nil {
+		_ = cr.close()
+	}
+	return nil
+}
+
+// Close closes the current file, when there is one.
+func (cr *concatReader) Close() error {
+	if cr.current == nil {
+		return nil
+	}
+	return cr.close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/karrick/lines/linesel"
+)
+
+func TestConcatReader(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a":     "a1\na2\n",
+		"b":     "b1\nb2", // no final newline
+		"c":     "c1\n",
+		"empty": "",
+		"stdin": "s1\ns2",
+		"gz":    gzipped(t, "z1\nz2"),
+	})
+	stdin, err := os.Open(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	setOption(t, &os.Stdin, stdin)
+
+	cases := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{"single file", []string{"a"}, "a1\na2\n"},
+		{"newline supplied", []string{"b", "c"}, "b1\nb2\nc1\n"},
+		{"newline supplied at end", []string{"a", "b"}, "a1\na2\nb1\nb2\n"},
+		{"empty file", []string{"a", "empty", "c"}, "a1\na2\nc1\n"},
+		{"compressed file", []string{"gz", "c"}, "z1\nz2\nc1\n"},
+		{"standard input", []string{"a", "-", "c"}, "a1\na2\ns1\ns2\nc1\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var names []string
+			for _, name := range tc.names {
+				if name != "-" {
+					name = filepath.Join(dir, name)
+				}
+				names = append(names, name)
+			}
+			cr := newConcatReader(names, bufio.NewWriter(io.Discard))
+			got, err := io.ReadAll(cr)
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if err = cr.Close(); err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if string(got) != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConcatReaderMissingFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a": "a1\n", "b": "b1\n"})
+	names := []string{filepath.Join(dir, "a"), filepath.Join(dir, "missing"), filepath.Join(dir, "b")}
+
+	cr := newConcatReader(names, bufio.NewWriter(io.Discard))
+	got, err := io.ReadAll(cr)
+	if want := "cannot read \"" + names[1] + "\""; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("GOT: %v; WANT: %s", err, want)
+	}
+	if want := "a1\n"; string(got) != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestConcatForcePastMissingFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a": "1\n2\n3\n4\n5\n", "b": "6\n7\n8\n"})
+	args := []string{filepath.Join(dir, "a"), filepath.Join(dir, "missing"), filepath.Join(dir, "b")}
+	setOption(t, optConcat, true)
+	setOption(t, optForce, true)
+
+	// Print both the lines and the warning to the same file, to observe the
+	// order in which they are printed.
+	combined, err := os.Create(filepath.Join(t.TempDir(), "combined"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer combined.Close()
+	setOption(t, &os.Stderr, combined)
+
+	ranges, err := linesel.ParseRanges("4-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = filter(combined, args, selectStage(ranges), nil); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+
+	got, err := os.ReadFile(combined.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(got), "\n")
+	if len(lines) != 6 || lines[0] != "4" || lines[1] != "5" || !strings.Contains(lines[2], "cannot read \""+args[1]+"\"") || lines[3] != "6" || lines[4] != "7" {
+		t.Errorf("GOT: %q; WANT: lines 4 and 5, the warning, then lines 6 and 7", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Formats accepted by --decompress.
+const (
+	decompressAuto  = "auto"  // detect the format of each input by its magic bytes
+	decompressNone  = "none"  // never decompress
+	decompressGzip  = "gzip"  // RFC 1952, including multiple concatenated members
+	decompressBzip2 = "bzip2" // bzip2, including multiple concatenated streams
+	decompressZlib  = "zlib"  // RFC 1950
+)
+
+// sniffSize is the number of initial bytes of an input examined to determine
+// its compression format.
+const sniffSize = 10
+
+// sniffFormat returns the compression format indicated by the initial bytes of
+// an input, or decompressNone when they do not indicate a supported format.
+// Because text may begin with the same bytes as a compressed stream, as much of
+// the header of each format as fits in sniffSize bytes is checked.
+func sniffFormat(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return decompressGzip
+	case isBzip2(magic):
+		return decompressBzip2
+	case isZlib(magic):
+		return decompressZlib
+	}
+	return decompressNone
+}
+
+// isBzip2 returns true when magic begins with a bzip2 stream header, which is
+// "BZh" and a block size digit, followed by the magic number of either a
+// compressed block, or the end of an empty stream.
+func isBzip2(magic []byte) bool {
+	if len(magic) < 10 || !bytes.HasPrefix(magic, []byte("BZh")) || magic[3] < '1' || magic[3] > '9' {
+		return false
+	}
+	block := magic[4:10]
+	return bytes.Equal(block, []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}) || bytes.Equal(block, []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90})
+}
+
+// isZlib returns true when magic begins with a zlib header for a deflate stream
+// with a 32 KiB window and no preset dictionary, whose check bits are valid.
+func isZlib(magic []byte) bool {
+	if len(magic) < 2 || magic[0] != 0x78 || magic[1]&0x20 != 0 {
+		return false
+	}
+	if (uint(magic[0])<<8|uint(magic[1]))%31 != 0 {
+		return false
+	}
+	// The header zlib writes for compression levels 2 thru 5 is "x^", which
+	// is as likely to begin text, so only the headers for its other levels
+	// are accepted.
+	return magic[1] != '^'
+}
+
+// detectFormat returns the compression format of r as given by --decompress,
+// or when it is auto, as indicated by the initial bytes of r. When those bytes
+// must be consumed to be examined, such as when r is a pipe, the returned
+// io.Reader provides them again, followed by the rest of r, and only the bytes
+// provided by the first read of r are examined. Otherwise it returns r itself.
+func detectFormat(r io.Reader) (string, io.Reader, error) {
+	if *optDecompress != decompressAuto {
+		return *optDecompress, r, nil
+	}
+
+	// A regular file may be examined without consuming any of it.
+	if fh, ok := r.(*os.File); ok {
+		if fi, err := fh.Stat(); err == nil && fi.Mode().IsRegular() {
+			if offset, err := fh.Seek(0, io.SeekCurrent); err == nil {
+				magic := make([]byte, sniffSize)
+				n, err := fh.ReadAt(magic, offset)
+				if err != nil && err != io.EOF {
+					return "", nil, err
+				}
+				return sniffFormat(magic[:n]), fh, nil
+			}
+		}
+	}
+
+	// Rather than wait for sniffSize bytes to arrive, which for a pipe may
+	// delay printing a short initial line indefinitely, only the bytes that
+	// arrive with the first read are examined. Too few bytes to identify a
+	// format are taken to be uncompressed.
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err != nil {
+		if err == io.EOF {
+			return decompressNone, br, nil
+		}
+		return "", nil, err
+	}
+	n := br.Buffered()
+	if n > sniffSize {
+		n = sniffSize
+	}
+	magic, _ := br.Peek(n) // cannot fail for buffered bytes
+	return sniffFormat(magic), br, nil
+}
+
+// decompress returns an io.Reader that provides the decompressed contents of
+// r, or r itself when it is not compressed, so that regular files that are not
+// compressed may still be read in the most efficient manner.
+func decompress(r io.Reader) (io.Reader, error) {
+	format, r, err := detectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case decompressNone:
+		return r, nil
+	case decompressGzip:
+		return gzip.NewReader(r) // reads multiple members by default
+	case decompressBzip2:
+		return bzip2.NewReader(r), nil
+	case decompressZlib:
+		return zlib.NewReader(r)
+	}
+
+	return nil, fmt.Errorf("cannot decompress unknown format: %q", format)
+}
+
+// withInput invokes callback with the decompressed contents of the named file,
+// as described by decompress.
+func withInput(path string, callback func(io.Reader) error) error {
+	return withOpenFile(path, func(fh *os.File) error {
+		r, err := decompress(fh)
+		if err != nil {
+			return err
+		}
+		return callback(r)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karrick/lines/linesel"
+)
+
+func TestSniffFormat(t *testing.T) {
+	zlibLevel := func(level int) []byte {
+		var b bytes.Buffer
+		zw, err := zlib.NewWriterLevel(&b, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = zw.Write([]byte("line\n"))
+		if err = zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	cases := []struct {
+		name  string
+		magic []byte
+		want  string
+	}{
+		{"empty", nil, decompressNone},
+		{"text", []byte("some text\n"), decompressNone},
+		{"gzip", []byte(gzipped(t, "line\n")), decompressGzip},
+		{"bzip2 block", []byte("BZh9\x31\x41\x59\x26\x53\x59"), decompressBzip2},
+		{"bzip2 empty stream", []byte("BZh1\x17\x72\x45\x38\x50\x90"), decompressBzip2},
+		{"bzip2 prefix of text", []byte("BZh is a prefix\n"), decompressNone},
+		{"bzip2 without level", []byte("BZh0\x31\x41\x59\x26\x53\x59"), decompressNone},
+		{"bzip2 truncated", []byte("BZh9\x31\x41"), decompressNone},
+		{"zlib default", zlibLevel(zlib.DefaultCompression), decompressZlib},
+		{"zlib fastest", zlibLevel(zlib.BestSpeed), decompressZlib},
+		{"zlib best", zlibLevel(zlib.BestCompression), decompressZlib},
+		{"zlib header of text", []byte("x^2 + y\n"), decompressNone},
+		{"zlib bad check bits", []byte{0x78, 0x9d, 0, 0}, decompressNone},
+		{"zlib preset dictionary", []byte{0x78, 0xbb, 0, 0}, decompressNone},
+		{"letter x", []byte("xyz\n"), decompressNone},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			magic := tc.magic
+			if len(magic) > sniffSize {
+				magic = magic[:sniffSize]
+			}
+			if got := sniffFormat(magic); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// gzipped returns the gzip compressed form of s.
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, _ = zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// zlibbed returns the zlib compressed form of s.
+func zlibbed(t *testing.T, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	_, _ = zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestDecompress(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"text", "hello\n", "hello\n"},
+		{"gzip", gzipped(t, "hello\nworld\n"), "hello\nworld\n"},
+		{"gzip members", gzipped(t, "hello\n") + gzipped(t, "world\n"), "hello\nworld\n"},
+		{"zlib", zlibbed(t, "hello\nworld\n"), "hello\nworld\n"},
+		{"bzip2 prefix of text", "BZh is a prefix\nline 2\n", "BZh is a prefix\nline 2\n"},
+		// Output of: printf 'hello\n' | bzip2
+		{"bzip2", "BZh91AY&SY\xc1\xc0\x80\xe2\x00\x00\x01A\x00\x00\x10\x02D\xa0\x000\xcd\x00\xc3F)\x97\x17rE8P\x90\xc1\xc0\x80\xe2", "hello\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := decompress(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if string(got) != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecompressDoesNotWaitForMoreInput(t *testing.T) {
+	// A short initial line from a pipe that remains open is provided without
+	// waiting for enough bytes to identify every format.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() { _, _ = pw.Write([]byte("hi\n")) }()
+
+	done := make(chan io.Reader, 1)
+	go func() {
+		r, err := decompress(pr)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		if r == nil {
+			return
+		}
+		if got, want := readWithin(t, r, 3), "hi\n"; got != want {
+			t.Errorf("GOT: %q; WANT: %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GOT: waiting for more input; WANT: format detected")
+	}
+}
+
+func TestDecompressCorruptFileWithForce(t *testing.T) {
+	corrupt := gzipped(t, strings.Repeat("lost line\n", 100))
+	corrupt = corrupt[:len(corrupt)/2] + "garbage" + corrupt[len(corrupt)/2:]
+
+	dir := writeFiles(t, map[string]string{
+		"a.gz":       gzipped(t, "a1\n"),
+		"corrupt.gz": corrupt,
+		"b.gz":       gzipped(t, "b1\n"),
+	})
+	args := []string{filepath.Join(dir, "a.gz"), filepath.Join(dir, "corrupt.gz"), filepath.Join(dir, "b.gz")}
+	setOption(t, optForce, true)
+	setOption(t, optNoFilename, true)
+
+	got, warnings, err := runFilter(t, args, selectStage(linesel.Bottom(1)))
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if want := "a1\nb1\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+	if want := ProgramName + ": cannot read \"" + args[1] + "\": "; !strings.HasPrefix(warnings, want) || strings.Count(warnings, "\n") != 1 {
+		t.Errorf("GOT: %q; WANT: %q", warnings, want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/karrick/lines/linesel"
+)
+
+// followInterval is how often a followed file is checked for appended lines.
+var followInterval = time.Second
+
+// followFilter returns the text to print for a line appended to a followed file
+// after its initial lines were printed, and whether to print it at all, given
+// its line number, and the number of initial lines in the file.
+type followFilter func(lineNumber, initial int, line []byte) ([]byte, bool)
+
+// newFollowFilter returns a followFilter that selects the appended lines the
+// skip-top and range stages would have selected, where hasRange is false when
+// there is no range stage. The bottom stage would print every line appended to
+// the file, so it need not select them.
+func newFollowFilter(skipTop linesel.Amount, ranges linesel.Ranges, hasRange bool) followFilter {
+	return func(lineNumber, initial int, line []byte) ([]byte, bool) {
+		skipped := skipTop.Lines(initial)
+		if lineNumber <= skipped {
+			return nil, false
+		}
+		if hasRange {
+			return ranges.Match(lineNumber-skipped, line)
+		}
+		return line, true
+	}
+}
+
+// followFile invokes callback with the complete lines presently in fh, then
+// waits for lines to be appended to fh, printing each one for which filter
+// returns true, until an error occurs. When name is not empty, fh is followed
+// by name as described by followLines. When fh is not a regular file, there is
+// nothing to follow, so it merely invokes callback with fh.
+func followFile(fh *os.File, name string, w io.Writer, callback stage, filter followFilter) error {
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		verbose("cannot follow %q: not a regular file\n", fh.Name())
+		r, err := decompress(fh)
+		if err != nil {
+			return err
+		}
+		return callback(r, w)
+	}
+
+	if format, _, err := detectFormat(fh); err != nil {
+		return err
+	} else if format != decompressNone {
+		return fmt.Errorf("cannot follow %s compressed file", format)
+	}
+
+	offset, err := fh.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	// A final line that does not yet end with a newline may still be in the
+	// process of being written, so it is left to be read while following.
+	end, err := lastNewline(fh, offset, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	lc := newLineCounter()
+	initial := io.TeeReader(io.NewSectionReader(fh, offset, end-offset), lc)
+
+	if err = callback(initial, w); err != nil {
+		return err
+	}
+
+	// Some stages stop reading once they have printed their lines, but every
+	// initial line must be counted.
+	runningStages.Wait()
+	if _, err = io.Copy(ioutil.Discard, initial); err != nil {
+		return err
+	}
+
+	return followLines(fh, name, w, end, lc.count(), filter)
+}
+
+// followLines prints each line appended to fh beyond offset, which follows the
+// final newline of the initial lines of fh, for which filter returns true. When
+// the file is truncated, its lines are printed again from its start. When name
+// is not empty, the file is followed by name rather than by descriptor, so when
+// name is replaced by another file, such as when a log file is rotated, the
+// lines of the new file are printed from its start.
+func followLines(fh *os.File, name string, w io.Writer, offset int64, initial int, filter followFilter) (err error) {
+	buf := make([]byte, 64*1024)
+	var pending []byte // bytes of a line not yet terminated by a newline
+	var owned bool     // true after fh was reopened by name, and must be closed
+	lineNumber := initial
+
+	// restart causes the lines of the followed file to be printed from its
+	// start, numbered as though none of them had been printed.
+	restart := func() {
+		offset, initial, lineNumber, pending = 0, 0, 0, nil
+	}
+
+	defer func() {
+		if !owned {
+			return // initial file is closed by its opener
+		}
+		if err2 := fh.Close(); err == nil {
+			err = err2
+		}
+	}()
+
+	for {
+		n, err := fh.ReadAt(buf, offset)
+		offset += int64(n)
+		pending = append(pending, buf[:n]...)
+
+		for {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 {
+				break
+			}
+			line, rest := bytes.TrimSuffix(pending[:i], []byte{'\r'}), []byte(nil)
+			pending = pending[i+1:]
+			for line != nil {
+				lineNumber++
+				var lerr error
+				if line, rest, lerr = limitLine(line, lineNumber); lerr != nil {
+					return lerr
+				}
+				if line, ok := filter(lineNumber, initial, line); ok {
+					if err := linesel.WriteLine(w, lineNumber, line); err != nil {
+						return err
+					}
+				}
+				line = rest
+			}
+		}
+
+		if err != io.EOF {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := flushWriter(w); err != nil {
+			return err
+		}
+		pending = append([]byte(nil), pending...) // release consumed bytes
+		time.Sleep(followInterval)
+
+		fi, err := fh.Stat()
+		if err != nil {
+			return err
+		}
+
+		if name != "" {
+			if nfi, err := os.Stat(name); err == nil && !os.SameFile(fi, nfi) {
+				// Only switch to the new file after reading what remains of
+				// the replaced file.
+				if n, _ := fh.ReadAt(buf[:1], offset); n > 0 {
+					continue
+				}
+				nfh, err := os.Open(name)
+				if err != nil {
+					verbose("cannot reopen %q: %s\n", name, err)
+					continue
+				}
+				verbose("%q has been replaced; following new file\n", name)
+				if owned {
+					_ = fh.Close()
+				}
+				fh, owned = nfh, true
+				restart()
+				continue
+			}
+		}
+
+		if fi.Size() < offset {
+			verbose("%q has been truncated; following from its start\n", fh.Name())
+			restart()
+		}
+	}
+}
+
+// lastNewline returns the offset of the byte following the final newline in fh
+// between offset and size, or offset when there is no newline between them.
+func lastNewline(fh *os.File, offset, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+
+	for end := size; end > offset; {
+		start := end - int64(len(buf))
+		if start < offset {
+			start = offset
+		}
+		n, err := fh.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karrick/lines/linesel"
+)
+
+// errRecorded is returned by a lineRecorder once it has recorded its limit.
+var errRecorded = errors.New("recorded every line")
+
+// lineRecorder is a linesel.LineWriter that records each line written to it,
+// prefixed by its line number and a colon, which returns errRecorded once it
+// has recorded limit lines, so that following a file stops.
+type lineRecorder struct {
+	mu    sync.Mutex
+	lines []string
+	limit int
+}
+
+func (lr *lineRecorder) Write(p []byte) (int, error) {
+	return 0, errors.New("lines must be written by WriteLine")
+}
+
+func (lr *lineRecorder) WriteLine(lineNumber int, line []byte) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.lines = append(lr.lines, strconv.Itoa(lineNumber)+":"+string(line))
+	if len(lr.lines) >= lr.limit {
+		return errRecorded
+	}
+	return nil
+}
+
+// recorded returns the lines recorded thus far.
+func (lr *lineRecorder) recorded() []string {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return append([]string(nil), lr.lines...)
+}
+
+// waitRecorded waits for lr to record n lines, failing the test when they are
+// not recorded within a few seconds.
+func waitRecorded(t *testing.T, lr *lineRecorder, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(lr.recorded()) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("GOT: %q; WANT: %d lines", lr.recorded(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// followed follows the named file, which is followed by name when byName is
+// true, printing its lines with callback and filter to lr. It returns a channel
+// that receives the error returned by followFile.
+func followed(t *testing.T, name string, byName bool, lr *lineRecorder, callback stage, filter followFilter) <-chan error {
+	t.Helper()
+	fh, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = fh.Close() })
+
+	if !byName {
+		name = ""
+	}
+	done := make(chan error, 1)
+	go func() { done <- followFile(fh, name, lr, callback, filter) }()
+	return done
+}
+
+// appendFile appends data to the named file.
+func appendFile(t *testing.T, name, data string) {
+	t.Helper()
+	fh, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fh.WriteString(data)
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFollowed waits for following a file to stop, failing the test unless it
+// stopped because every expected line was recorded.
+func waitFollowed(t *testing.T, done <-chan error, lr *lineRecorder, want []string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != errRecorded {
+			t.Fatalf("GOT: %v; WANT: %v", err, errRecorded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("GOT: %q; WANT: %q", lr.recorded(), want)
+	}
+	if got := lr.recorded(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestFollowHoldsUnterminatedLine(t *testing.T) {
+	setOption(t, &followInterval, time.Millisecond)
+	name := filepath.Join(writeFiles(t, map[string]string{"log": "1\n2\npart"}), "log")
+
+	lr := &lineRecorder{limit: 4}
+	done := followed(t, name, false, lr, selectStage(linesel.Skip{}), newFollowFilter(linesel.Amount{}, linesel.Ranges{}, false))
+
+	// The final line is not printed until its newline is appended, however
+	// many times the file is checked.
+	waitRecorded(t, lr, 2)
+	time.Sleep(20 * followInterval)
+	if got := lr.recorded(); len(got) != 2 {
+		t.Errorf("GOT: %q; WANT: %d lines", got, 2)
+	}
+
+	appendFile(t, name, "ial")
+	time.Sleep(20 * followInterval)
+	appendFile(t, name, "\n3\n")
+	waitFollowed(t, done, lr, []string{"1:1", "2:2", "3:partial", "4:3"})
+}
+
+func TestFollowFiltersAppendedLines(t *testing.T) {
+	setOption(t, &followInterval, time.Millisecond)
+
+	skipTop, err := parseAmount("--skip-top", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := linesel.ParseRanges("2-3,5-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		callback stage
+		filter   followFilter
+		initial  int // number of lines printed before any are appended
+		want     []string
+	}{
+		{
+			name:     "skip top",
+			callback: selectStage(linesel.Skip{Initial: 2}),
+			filter:   newFollowFilter(skipTop, linesel.Ranges{}, false),
+			initial:  2,
+			want:     []string{"3:a", "4:b", "5:c", "6:d", "7:e"},
+		},
+		{
+			name:     "range",
+			callback: selectStage(ranges),
+			filter:   newFollowFilter(linesel.Amount{}, ranges, true),
+			initial:  2,
+			want:     []string{"2:h2", "3:a", "5:c", "6:d", "7:e"},
+		},
+		{
+			// Lines of the range are counted after the skipped lines, while
+			// each line is numbered by its line number in the file.
+			name:     "skip top and range",
+			callback: pipeline(selectStage(linesel.Skip{Initial: 2}), selectStage(ranges)),
+			filter:   newFollowFilter(skipTop, ranges, true),
+			initial:  1,
+			want:     []string{"4:b", "5:c", "7:e"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(writeFiles(t, map[string]string{"log": "h1\nh2\na\nb\n"}), "log")
+
+			lr := &lineRecorder{limit: len(tc.want)}
+			done := followed(t, name, false, lr, tc.callback, tc.filter)
+			waitRecorded(t, lr, tc.initial)
+
+			appendFile(t, name, "c\nd\ne\n")
+			waitFollowed(t, done, lr, tc.want)
+		})
+	}
+}
+
+func TestLastNewline(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+
+	cases := []struct {
+		name     string
+		contents string
+		offset   int64
+		want     int64
+	}{
+		{"empty", "", 0, 0},
+		{"terminated", "1\n2\n", 0, 4},
+		{"unterminated", "1\n2", 0, 2},
+		{"no newline", "partial", 0, 0},
+		{"no newline after offset", "1\n2", 2, 2},
+		{"newline before long line", "1\n" + long, 0, 2},
+		{"newline after long line", long + "\n" + long, 0, 5001},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fh, err := os.Open(filepath.Join(writeFiles(t, map[string]string{"log": tc.contents}), "log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+
+			got, err := lastNewline(fh, tc.offset, int64(len(tc.contents)))
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if got != tc.want {
+				t.Errorf("GOT: %d; WANT: %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFollowNameReadsReplacedFile(t *testing.T) {
+	setOption(t, &followInterval, 20*time.Millisecond)
+	name := filepath.Join(writeFiles(t, map[string]string{"log": "1\n2\n"}), "log")
+
+	lr := &lineRecorder{limit: 5}
+	done := followed(t, name, true, lr, selectStage(linesel.Skip{}), newFollowFilter(linesel.Amount{}, linesel.Ranges{}, false))
+	waitRecorded(t, lr, 2)
+
+	// Rotate the file, while its writer still appends to it, before the
+	// follower next checks it, so that the rest of the renamed file remains
+	// to be read once the new file is noticed.
+	writer, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err = os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.WriteString("3\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(name, []byte("new 1\nnew 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new file is printed from its start, numbered from 1.
+	waitFollowed(t, done, lr, []string{"1:1", "2:2", "3:3", "1:new 1", "2:new 2"})
+}
+
+func TestFollowDescriptorIgnoresReplacedFile(t *testing.T) {
+	setOption(t, &followInterval, time.Millisecond)
+	name := filepath.Join(writeFiles(t, map[string]string{"log": "1\n"}), "log")
+
+	lr := &lineRecorder{limit: 2}
+	done := followed(t, name, false, lr, selectStage(linesel.Skip{}), newFollowFilter(linesel.Amount{}, linesel.Ranges{}, false))
+	waitRecorded(t, lr, 1)
+
+	writer, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err = os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(name, []byte("new 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * followInterval)
+	if _, err = writer.WriteString("2\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFollowed(t, done, lr, []string{"1:1", "2:2"})
+}
+
+func TestFollowTruncatedFile(t *testing.T) {
+	setOption(t, &followInterval, time.Millisecond)
+
+	for _, byName := range []bool{false, true} {
+		t.Run("by name "+strconv.FormatBool(byName), func(t *testing.T) {
+			name := filepath.Join(writeFiles(t, map[string]string{"log": "1\n2\n3\n"}), "log")
+
+			lr := &lineRecorder{limit: 5}
+			done := followed(t, name, byName, lr, selectStage(linesel.Skip{}), newFollowFilter(linesel.Amount{}, linesel.Ranges{}, false))
+			waitRecorded(t, lr, 3)
+
+			// The file is printed again from its start, numbered from 1.
+			if err := os.Truncate(name, 0); err != nil {
+				t.Fatal(err)
+			}
+			appendFile(t, name, "a\n")
+			waitRecorded(t, lr, 4)
+			appendFile(t, name, "b\n")
+			waitFollowed(t, done, lr, []string{"1:1", "2:2", "3:3", "1:a", "2:b"})
+		})
+	}
+}
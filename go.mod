@@ -1,9 +1,8 @@
 module github.com/karrick/lines
 
-go 1.15
+go 1.18
 
 require (
 	github.com/karrick/gobls v1.3.5
 	github.com/karrick/golf v1.4.0
-	github.com/karrick/gotb v0.1.0
 )
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// indexSuffix is appended to the name of a file to form the name of its index.
+const indexSuffix = ".lines-index"
+
+// indexHeader is the first word of the first line of an index, followed by the
+// version of its format.
+const indexHeader = "lines-index"
+
+// indexVersion is the version of the format of an index.
+const indexVersion = 1
+
+// indexInterval is the number of lines between the lines whose offsets are
+// recorded in an index.
+const indexInterval = 4096
+
+// lineIndex records the byte offset of every interval-th line of a file, such
+// that offsets[k] is the byte offset of line k*interval+1.
+//
+// An index is stored in a text file whose name is the name of the file it
+// indexes followed by indexSuffix. Its first line holds indexHeader, the format
+// version, the size of the indexed file in bytes, its modification time in
+// nanoseconds since the Unix epoch, and the interval, separated by spaces. Each
+// following line holds one offset, in order.
+type lineIndex struct {
+	size     int64
+	modified int64
+	interval int
+	offsets  []int64
+}
+
+// indexFiles writes an index for each of the named files.
+func indexFiles(args []string) error {
+	if len(args) == 0 {
+		return NewErrUsage("cannot index without a file name.")
+	}
+
+	for _, arg := range args {
+		err := withOpenFile(arg, func(fh *os.File) error {
+			return writeIndex(fh)
+		})
+		if err != nil {
+			err = fmt.Errorf("cannot index %q: %s", arg, err)
+			if !*optForce {
+				return err
+			}
+			warning("%s\n", err)
+		}
+	}
+
+	return nil
+}
+
+// writeIndex writes an index for fh, replacing any existing index for it.
+func writeIndex(fh *os.File) (err error) {
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+
+	// Offsets of compressed bytes are useless, because an index is only used
+	// when a file is read without decompressing it.
+	format, _, err := detectFormat(fh)
+	if err != nil {
+		return err
+	}
+	if format != decompressNone {
+		return fmt.Errorf("compressed with %s", format)
+	}
+
+	li := &lineIndex{size: fi.Size(), modified: fi.ModTime().UnixNano(), interval: indexInterval, offsets: []int64{0}}
+
+	buf := make([]byte, 64*1024)
+	var offset int64
+	var lines int
+
+	for {
+		n, err := fh.Read(buf)
+		block := buf[:n]
+		for {
+			i := bytes.IndexByte(block, '\n')
+			if i < 0 {
+				break
+			}
+			offset += int64(i) + 1
+			block = block[i+1:]
+			if lines++; lines%indexInterval == 0 && offset < li.size {
+				li.offsets = append(li.offsets, offset)
+			}
+		}
+		offset += int64(len(block))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Refuse to write an index that may not match the file.
+	if fi2, err := fh.Stat(); err != nil {
+		return err
+	} else if fi2.Size() != li.size || fi2.ModTime().UnixNano() != li.modified || offset != li.size {
+		return errors.New("file changed while being indexed")
+	}
+
+	// Write the index to a temporary file in the same directory, then rename
+	// it, so a reader never observes a partially written index.
+	name := fh.Name() + indexSuffix
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+"-")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	fmt.Fprintf(bw, "%s %d %d %d %d\n", indexHeader, indexVersion, li.size, li.modified, li.interval)
+	for _, offset := range li.offsets {
+		fmt.Fprintln(bw, offset)
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	// A temporary file may only be read by its owner, but anyone who may read
+	// the file may use its index.
+	if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	verbose("wrote index of %d lines to %q\n", lines, name)
+	return os.Rename(tmp.Name(), name)
+}
+
+// readIndex returns the index for fh, or nil when fh has no index, or when its
+// index is stale because fh changed after it was indexed.
+func readIndex(fh *os.File) (*lineIndex, error) {
+	name := fh.Name() + indexSuffix
+
+	ih, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer ih.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	li := new(lineIndex)
+	scanner := bufio.NewScanner(ih)
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("cannot read index %q: missing header", name)
+	}
+	var header string
+	var version int
+	if _, err := fmt.Sscanf(scanner.Text(), "%s %d %d %d %d", &header, &version, &li.size, &li.modified, &li.interval); err != nil || header != indexHeader || li.interval < 1 {
+		return nil, fmt.Errorf("cannot read index %q: invalid header", name)
+	}
+	if version != indexVersion {
+		verbose("ignoring index %q with unsupported version %d\n", name, version)
+		return nil, nil
+	}
+	if li.size != fi.Size() || li.modified != fi.ModTime().UnixNano() {
+		verbose("ignoring stale index %q; use `%s index %s` to rebuild it\n", name, ProgramName, fh.Name())
+		return nil, nil
+	}
+
+	for scanner.Scan() {
+		offset, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 64)
+		if err != nil || offset < 0 || offset > li.size {
+			return nil, fmt.Errorf("cannot read index %q: invalid offset: %q", name, scanner.Text())
+		}
+		li.offsets = append(li.offsets, offset)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read index %q: %s", name, err)
+	}
+	if len(li.offsets) == 0 || li.offsets[0] != 0 {
+		return nil, fmt.Errorf("cannot read index %q: invalid offsets", name)
+	}
+
+	return li, nil
+}
+
+// seek returns the byte offset of the latest indexed line that is not after
+// lineNumber, along with the number of lines that precede that offset.
+func (li *lineIndex) seek(lineNumber int) (int64, int) {
+	k := (lineNumber - 1) / li.interval
+	if k >= len(li.offsets) {
+		k = len(li.offsets) - 1
+	}
+	if k < 0 {
+		k = 0
+	}
+	return li.offsets[k], k * li.interval
+}
+
+// seekIndexed returns a reader positioned at or before the line numbered
+// lineNumber of r, along with the number of lines before that position, when r
+// is a regular file positioned at its start that has a valid index. Otherwise
+// it returns r and 0.
+func seekIndexed(r io.Reader, lineNumber int) (io.Reader, int, error) {
+	fh, ok := r.(*os.File)
+	if !ok || lineNumber <= 1 || *optMaxLineLength > 0 {
+		// An index records lines before the --long-lines policy is applied.
+		return r, 0, nil
+	}
+	if offset, err := fh.Seek(0, io.SeekCurrent); err != nil || offset != 0 {
+		return r, 0, nil // not seekable, or not at the start of the file
+	}
+
+	li, err := readIndex(fh)
+	if err != nil {
+		// An index is merely an optimization, so ignore an invalid one.
+		warning("%s\n", err)
+		return r, 0, nil
+	}
+	if li == nil {
+		return r, 0, nil
+	}
+
+	offset, skipped := li.seek(lineNumber)
+	if _, err = fh.Seek(offset, io.SeekStart); err != nil {
+		return r, 0, err
+	}
+	verbose("index of %q skips %d lines\n", fh.Name(), skipped)
+	return fh, skipped, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// indexedLine returns the text of line n of the files written by writeLines,
+// which vary in length so that line offsets are not multiples of each other.
+func indexedLine(n int) string {
+	return strconv.Itoa(n) + strings.Repeat(".", n%7)
+}
+
+// writeLines writes a file of n lines in dir, returning its name.
+func writeLines(t *testing.T, dir string, n int) string {
+	t.Helper()
+	name := filepath.Join(dir, "input.txt")
+	fh, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bw := bufio.NewWriter(fh)
+	for i := 1; i <= n; i++ {
+		bw.WriteString(indexedLine(i))
+		bw.WriteByte('\n')
+	}
+	if err = bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLineIndexSeek(t *testing.T) {
+	li := &lineIndex{interval: 4, offsets: []int64{0, 10, 20}}
+
+	cases := []struct {
+		lineNumber int
+		offset     int64
+		skipped    int
+	}{
+		{0, 0, 0},
+		{1, 0, 0},
+		{4, 0, 0},
+		{5, 10, 4},
+		{8, 10, 4},
+		{9, 20, 8},
+		{100, 20, 8},
+	}
+
+	for _, tc := range cases {
+		offset, skipped := li.seek(tc.lineNumber)
+		if offset != tc.offset || skipped != tc.skipped {
+			t.Errorf("line %d: GOT: %d, %d; WANT: %d, %d", tc.lineNumber, offset, skipped, tc.offset, tc.skipped)
+		}
+	}
+}
+
+func TestSeekIndexed(t *testing.T) {
+	const lines = 3*indexInterval + 10
+	name := writeLines(t, t.TempDir(), lines)
+
+	fh, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	if err = writeIndex(fh); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+
+	for _, lineNumber := range []int{2, indexInterval, indexInterval + 1, indexInterval + 2, 2*indexInterval + 1, 3*indexInterval + 5, lines + 100} {
+		if _, err = fh.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		r, skipped, err := seekIndexed(fh, lineNumber)
+		if err != nil {
+			t.Fatalf("GOT: %v; WANT: %v", err, nil)
+		}
+		if want := (lineNumber - 1) / indexInterval * indexInterval; skipped != want && lineNumber <= lines {
+			t.Errorf("line %d: GOT: %d skipped; WANT: %d", lineNumber, skipped, want)
+		}
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.TrimSuffix(line, "\n"), indexedLine(skipped+1); got != want {
+			t.Errorf("line %d: GOT: %q; WANT: %q", lineNumber, got, want)
+		}
+	}
+}
+
+func TestSeekIndexedIgnoresStaleIndex(t *testing.T) {
+	name := writeLines(t, t.TempDir(), 2*indexInterval)
+
+	fh, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	if err = writeIndex(fh); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fh.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, skipped, err := seekIndexed(fh, indexInterval+1)
+	if err != nil || skipped != 0 {
+		t.Errorf("GOT: %d, %v; WANT: %d, %v", skipped, err, 0, nil)
+	}
+}
+
+func TestWriteIndexRefusesCompressedFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "input.gz")
+	fh, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	zw := gzip.NewWriter(fh)
+	zw.Write([]byte(strings.Repeat("line\n", indexInterval+1)))
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fh.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writeIndex(fh); err == nil {
+		t.Errorf("GOT: %v; WANT: error", err)
+	}
+	if _, err = os.Stat(name + indexSuffix); !os.IsNotExist(err) {
+		t.Errorf("GOT: %v; WANT: index not written", err)
+	}
+}
+
+func TestWriteIndexCopiesPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not supported")
+	}
+	for _, perm := range []os.FileMode{0644, 0640, 0600} {
+		t.Run(perm.String(), func(t *testing.T) {
+			name := writeLines(t, t.TempDir(), 10)
+			if err := os.Chmod(name, perm); err != nil {
+				t.Fatal(err)
+			}
+			fh, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+
+			if err = writeIndex(fh); err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			fi, err := os.Stat(name + indexSuffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fi.Mode().Perm(); got != perm {
+				t.Errorf("GOT: %v; WANT: %v", got, perm)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package linesel
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a number of lines, given either as an absolute number of lines, or
+// as a percentage of the number of lines in an input, which is only known once
+// the input has been read. The zero value is 0 lines.
+type Amount struct {
+	n       int
+	percent *big.Rat // when not nil, n is ignored
+}
+
+// ParseAmount parses either a non-negative number of lines N, or a percentage
+// of the lines in the input P%, where P is a decimal number between 0 and 100
+// inclusive. The empty string is parsed as 0 lines. The error returned wraps
+// ErrInvalidAmount or ErrInvalidPercent.
+func ParseAmount(s string) (Amount, error) {
+	var a Amount
+
+	if s == "" {
+		return a, nil
+	}
+
+	if strings.HasSuffix(s, "%") {
+		p, err := parsePercent(s)
+		if err != nil {
+			return a, err
+		}
+		a.percent = p
+		return a, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return a, parseError(ErrInvalidAmount, "cannot parse number of lines: %q.", s)
+	}
+	a.n = n
+
+	return a, nil
+}
+
+// parsePercent parses a percentage P%, where P is a decimal number between 0 and
+// 100 inclusive.
+func parsePercent(s string) (*big.Rat, error) {
+	p, ok := new(big.Rat).SetString(strings.TrimSuffix(s, "%"))
+	if !ok || strings.ContainsAny(s, "/eE") || p.Sign() < 0 || p.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, parseError(ErrInvalidPercent, "cannot parse percentage between 0%% and 100%%: %q.", s)
+	}
+	return p, nil
+}
+
+// IsZero returns true when the amount is an absolute number of 0 lines.
+func (a Amount) IsZero() bool {
+	return a.percent == nil && a.n == 0
+}
+
+// IsPercent returns true when the amount is a percentage of the lines in the
+// input.
+func (a Amount) IsPercent() bool {
+	return a.percent != nil
+}
+
+// Lines returns the number of lines the amount represents when the input has
+// total lines. Percentages are rounded down to a whole number of lines, so 5%
+// of 10 lines is 0 lines, and 15% of 10 lines is 1 line.
+func (a Amount) Lines(total int) int {
+	if a.percent == nil {
+		return a.n
+	}
+	return percentOf(a.percent, total)
+}
+
+// percentOf returns the percentage p of total, rounded down to a whole number.
+func percentOf(p *big.Rat, total int) int {
+	n := new(big.Int).Mul(p.Num(), big.NewInt(int64(total)))
+	d := new(big.Int).Mul(p.Denom(), big.NewInt(100))
+	return int(n.Quo(n, d).Int64()) // both positive, so Quo rounds down
+}
@@ -0,0 +1,60 @@
+package linesel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		s       string
+		total   int
+		want    int
+		percent bool
+		err     error
+	}{
+		{s: "", total: 10, want: 0},
+		{s: "0", total: 10, want: 0},
+		{s: "7", total: 10, want: 7},
+		{s: "7", total: 3, want: 7},
+		{s: "0%", total: 10, want: 0, percent: true},
+		{s: "5%", total: 10, want: 0, percent: true},
+		{s: "10%", total: 10, want: 1, percent: true},
+		{s: "15%", total: 10, want: 1, percent: true},
+		{s: "19.99%", total: 10, want: 1, percent: true},
+		{s: "33.3%", total: 1000, want: 333, percent: true},
+		{s: "99.9%", total: 1000, want: 999, percent: true},
+		{s: "100%", total: 10, want: 10, percent: true},
+		{s: "100%", total: 0, want: 0, percent: true},
+		{s: "50%", total: 3, want: 1, percent: true},
+		{s: "-1", err: ErrInvalidAmount},
+		{s: "x", err: ErrInvalidAmount},
+		{s: "1.5", err: ErrInvalidAmount},
+		{s: "101%", err: ErrInvalidPercent},
+		{s: "-1%", err: ErrInvalidPercent},
+		{s: "1e1%", err: ErrInvalidPercent},
+		{s: "1/2%", err: ErrInvalidPercent},
+		{s: "%", err: ErrInvalidPercent},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.s, func(t *testing.T) {
+			a, err := ParseAmount(tc.s)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("GOT: %v; WANT: %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := a.IsPercent(); got != tc.percent {
+				t.Errorf("IsPercent: GOT: %v; WANT: %v", got, tc.percent)
+			}
+			if got, want := a.IsZero(), !tc.percent && tc.want == 0; got != want {
+				t.Errorf("IsZero: GOT: %v; WANT: %v", got, want)
+			}
+			if got := a.Lines(tc.total); got != tc.want {
+				t.Errorf("Lines(%d): GOT: %d; WANT: %d", tc.total, got, tc.want)
+			}
+		})
+	}
+}
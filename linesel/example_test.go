@@ -0,0 +1,115 @@
+package linesel_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/karrick/lines/linesel"
+)
+
+func ExampleCopy() {
+	text := "banner\nline 1\nline 2\nline 3\ntrailer\n"
+
+	// Omit a one line banner and a one line trailer.
+	err := linesel.Copy(os.Stdout, strings.NewReader(text), linesel.Skip{Initial: 1, Final: 1})
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// line 1
+	// line 2
+	// line 3
+}
+
+func ExampleParseRanges() {
+	text := "1\n2\n3\n4\nBEGIN\n6\nEND\n8\n9\n10\n"
+
+	rs, err := linesel.ParseRanges("1-2,/^BEGIN/,/^END/,-2:")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err = linesel.Copy(os.Stdout, strings.NewReader(text), rs); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// 1
+	// 2
+	// BEGIN
+	// 6
+	// END
+	// 9
+	// 10
+}
+
+func ExampleParseRanges_error() {
+	_, err := linesel.ParseRanges("5-3")
+	fmt.Println(errors.Is(err, linesel.ErrInvalidRange))
+	// Output:
+	// true
+}
+
+func ExampleInvert() {
+	text := "1\n2\n3\n4\n5\n6\n"
+
+	rs, err := linesel.ParseRanges("3-5")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Print every line except lines 3 thru 5.
+	if err = linesel.Copy(os.Stdout, strings.NewReader(text), linesel.Invert(rs)); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// 1
+	// 2
+	// 6
+}
+
+func ExampleInvert_selection() {
+	text := "1\n2\n3\n4\n5\n6\n"
+
+	// Print every line except the 3 lines following the first line.
+	sel := linesel.Selection{linesel.Skip{Initial: 1}, linesel.Top(3)}
+	if err := linesel.Copy(os.Stdout, strings.NewReader(text), linesel.Invert(sel)); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// 1
+	// 5
+	// 6
+}
+
+func ExampleNewReader() {
+	text := "name,count\napple,3\npear,5\n(2 rows)\n"
+
+	// Decode the CSV records following a header and preceding a footer.
+	sel := linesel.Skip{Initial: 1, Final: 1}
+	records, err := csv.NewReader(linesel.NewReader(strings.NewReader(text), sel)).ReadAll()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(records)
+	// Output:
+	// [[apple 3] [pear 5]]
+}
+
+func ExampleNewWriter() {
+	// Print the final 2 lines written, however the bytes are split.
+	w := linesel.NewWriter(os.Stdout, linesel.Bottom(2))
+	fmt.Fprint(w, "one\ntw")
+	fmt.Fprint(w, "o\nthree\nfo")
+	fmt.Fprint(w, "ur")
+	if err := w.Close(); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// three
+	// four
+}
@@ -0,0 +1,237 @@
+// Package linesel selects lines from text, such as the initial or final lines,
+// the lines that remain after omitting a header or footer, or the lines within
+// ranges addressed by line numbers, percentages, or patterns. It provides the
+// selection logic of the lines command to other programs.
+//
+// Each kind of selection is a Selector: Top, Bottom, Skip, Ranges, and
+// Selection. Copy copies the lines a Selector selects from an io.Reader to an
+// io.Writer:
+//
+//	// Omit a three line banner and a single line trailer.
+//	err := linesel.Copy(os.Stdout, resp.Body, linesel.Skip{Initial: 3, Final: 1})
+//
+//	// Print the final 10 lines, like tail.
+//	err := linesel.Copy(os.Stdout, fh, linesel.Bottom(10))
+//
+// Ranges are parsed from the same syntax the lines command accepts:
+//
+//	rs, err := linesel.ParseRanges("1-3,/^BEGIN/,/^END/,-5:")
+//	if err != nil {
+//		return err
+//	}
+//	err = linesel.Copy(os.Stdout, fh, rs)
+//
+// Invert returns the Selector for the lines another Selector would not select:
+//
+//	// Print every line except the lines rs selects.
+//	err := linesel.Copy(os.Stdout, fh, linesel.Invert(rs))
+//
+// A Selection applies several Selectors in stages, like the options of the
+// lines command, and NewReader provides the lines a Selector selects to
+// anything that reads from an io.Reader:
+//
+//	// Decode the CSV records between a one line header and footer.
+//	sel := linesel.Selection{linesel.Skip{Initial: 1, Final: 1}}
+//	records, err := csv.NewReader(linesel.NewReader(fh, sel)).ReadAll()
+//
+// NewWriter is the counterpart of NewReader for producers that push bytes, such
+// as the output of an exec.Cmd.
+//
+// Every selector reads its input only once, in order, and holds no more lines
+// in memory than it must, so that the final N lines of an input are the most
+// that are ever held, except by an inverted Selection, as described by Invert.
+// Selectors stop reading as soon as they know no further lines will be
+// selected.
+package linesel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/karrick/gobls"
+)
+
+var (
+	// ErrInvalidRange is wrapped by the error returned when a range cannot be
+	// parsed.
+	ErrInvalidRange = errors.New("invalid range")
+
+	// ErrInvalidPattern is wrapped by the error returned when a pattern
+	// address cannot be compiled.
+	ErrInvalidPattern = errors.New("invalid pattern")
+
+	// ErrInvalidPercent is wrapped by the error returned when a percentage is
+	// not between 0% and 100%.
+	ErrInvalidPercent = errors.New("invalid percentage")
+
+	// ErrInvalidAmount is wrapped by the error returned when a number of
+	// lines is neither a non-negative integer nor a percentage.
+	ErrInvalidAmount = errors.New("invalid number of lines")
+
+	// ErrNegative is returned when a Selector is given a negative number of
+	// lines.
+	ErrNegative = errors.New("cannot select a negative number of lines")
+
+	// ErrClosed is returned when writing to the io.WriteCloser returned by
+	// NewWriter after it has been closed.
+	ErrClosed = errors.New("cannot write lines after close")
+
+	// ErrUnresolved is returned when a Selector is used with a range addressed
+	// by a percentage that has not been resolved by Ranges.Resolve.
+	ErrUnresolved = errors.New("cannot select lines addressed by an unresolved percentage")
+)
+
+// ParseError is returned when a range, a pattern, or an amount cannot be
+// parsed. Its Err is one of ErrInvalidRange, ErrInvalidPattern,
+// ErrInvalidPercent, or ErrInvalidAmount, so it may be tested for using
+// errors.Is.
+type ParseError struct {
+	Err error
+	msg string
+}
+
+// parseError returns a ParseError that wraps err, described by the formatted
+// message.
+func parseError(err error, f string, a ...interface{}) error {
+	return &ParseError{Err: err, msg: fmt.Sprintf(f, a...)}
+}
+
+func (e *ParseError) Error() string { return e.msg }
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Scanner is a source of lines, such as a bufio.Scanner or a gobls.Scanner.
+// Bytes returns the most recently scanned line without its newline.
+type Scanner interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+}
+
+// NumberedScanner is a Scanner that knows the line number each line had in an
+// original input, which differs from its position in the input the Scanner
+// reads, such as when that input was itself selected from the original input.
+// The line numbers it returns are given to a LineWriter in place of the
+// position of each line.
+type NumberedScanner interface {
+	Scanner
+	LineNumber() int
+}
+
+// LineWriter is implemented by an io.Writer that needs the line number of each
+// line written to it. Each selected line is given to WriteLine, without its
+// newline, rather than to Write.
+type LineWriter interface {
+	WriteLine(lineNumber int, line []byte) error
+}
+
+// Selector selects lines from an input. Top, Bottom, Skip, Ranges, and
+// Selection are Selectors.
+type Selector interface {
+	// start returns the state used to select lines from a single input, which
+	// invokes emit for each line selected, or nil when no lines will be
+	// selected.
+	start(emit emitFunc) (selection, error)
+}
+
+// selection selects lines from a single input, which are presented to it one
+// at a time, in order.
+type selection interface {
+	// line presents the line at position n of the input, counting from 1,
+	// along with its line number, and emits each line selected, which may
+	// include lines presented earlier. It returns false when no further lines
+	// will be selected by line, so no further lines need be read.
+	line(n, lineNumber int, line []byte) (bool, error)
+
+	// end is invoked after the final line has been presented, either because
+	// the input has ended, or because line returned false, and emits each of
+	// the remaining lines selected.
+	end() error
+}
+
+// holder is implemented by a selection that holds lines it may yet emit, such
+// as the final lines of its input.
+type holder interface {
+	// held returns the line number of the oldest line held, or false when no
+	// lines are held.
+	held() (int, bool)
+}
+
+// emitFunc is invoked with each selected line, which is only valid until it
+// returns.
+type emitFunc func(lineNumber int, line []byte) error
+
+// Copy copies the lines sel selects from r to w, each followed by a newline.
+// Lines of any length are accepted.
+func Copy(w io.Writer, r io.Reader, sel Selector) error {
+	return Select(w, gobls.NewScanner(r), sel)
+}
+
+// Select copies the lines sel selects from s to w, each followed by a newline.
+// When w is a LineWriter, each line is instead given to WriteLine along with
+// its position in the input, or when s is a NumberedScanner, its original line
+// number. Select stops scanning as soon as no further lines will be selected.
+func Select(w io.Writer, s Scanner, sel Selector) error {
+	var buf []byte
+	st, err := sel.start(func(lineNumber int, line []byte) (err error) {
+		buf, err = writeLine(w, lineNumber, line, buf)
+		return err
+	})
+	if err != nil || st == nil {
+		return err
+	}
+
+	ns, numbered := s.(NumberedScanner)
+	var n int
+
+	for s.Scan() {
+		n++
+		lineNumber := n
+		if numbered {
+			lineNumber = ns.LineNumber()
+		}
+		more, err := st.line(n, lineNumber, s.Bytes())
+		if err != nil {
+			return err
+		}
+		if !more {
+			return st.end()
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return err
+	}
+
+	return st.end()
+}
+
+// WriteLine writes line to w, followed by a newline, as Select writes each
+// selected line. When w is a LineWriter, it is given the line along with
+// lineNumber instead. Unless w is a LineWriter or a bufio.Writer, WriteLine
+// copies line in order to write it with its newline in a single call to Write,
+// whereas Select and NewWriter reuse the storage for the copy of each line.
+func WriteLine(w io.Writer, lineNumber int, line []byte) error {
+	_, err := writeLine(w, lineNumber, line, nil)
+	return err
+}
+
+// writeLine writes line to w as WriteLine does. Unless w is a LineWriter or a
+// bufio.Writer, line and its newline are copied to buf, so that they are given
+// to w by a single call to Write, rather than being written separately, which
+// could separate them when writes to w are interleaved. It returns buf, which
+// may have grown, for writing the following line.
+func writeLine(w io.Writer, lineNumber int, line, buf []byte) ([]byte, error) {
+	switch lw := w.(type) {
+	case LineWriter:
+		return buf, lw.WriteLine(lineNumber, line)
+	case *bufio.Writer:
+		_, _ = lw.Write(line)
+		return buf, lw.WriteByte('\n') // bufio.Writer errors are sticky
+	}
+	buf = append(append(buf[:0], line...), '\n')
+	_, err := w.Write(buf)
+	return buf, err
+}
@@ -0,0 +1,1059 @@
+package linesel
+
+import (
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// patternMark stands in for each /PATTERN/ address while the remainder of a
+// range is parsed. ParseRanges rejects ranges that contain a NUL byte, so it
+// cannot collide with user input.
+const patternMark = "\x00"
+
+// maxInt is the largest line number that may be addressed.
+const maxInt = int(^uint(0) >> 1)
+
+// Ranges is a Selector that selects the lines within one or more ranges, each
+// addressed by line numbers, by percentages of the lines in the input, or by
+// patterns, such as those parsed by ParseRanges. The lines within every range
+// are selected in a single pass, in the order they appear in the input, and a
+// line within more than one range is only selected once. Only as many lines as
+// the largest end-relative address are held in memory. The zero value selects
+// no lines.
+type Ranges struct {
+	intervals []interval
+	invert    bool // select only the lines outside every interval
+	offset    int  // number of lines preceding the input, skipped by Seek
+}
+
+// ParseRanges parses a comma separated list of ranges, in the syntax accepted
+// by the '--range' option of the lines command. Each range is either a single
+// address N, START-END, START:END, START+COUNT, /PATTERN/, or /PATTERN/,END,
+// where START or END may be omitted, and any range may be followed by ~STEP.
+// Negative addresses in START:END count lines from the end of the input, and
+// any line number may be given as a percentage of the lines in the input, such
+// as 40%-60%. The error returned wraps ErrInvalidRange, ErrInvalidPattern, or
+// ErrInvalidPercent.
+func ParseRanges(s string) (Ranges, error) {
+	if strings.Contains(s, patternMark) {
+		return Ranges{}, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", s)
+	}
+	intervals, err := parseRanges(s)
+	if err != nil {
+		return Ranges{}, err
+	}
+	return Ranges{intervals: intervals}, nil
+}
+
+// PatternRange returns the range that begins with the first line that matches
+// the regular expression from, and ends with the following line that matches
+// the regular expression to. When from is empty the range begins with the first
+// line of the input, and when to is empty the range continues thru the final
+// line of the input.
+func PatternRange(from, to string) (Ranges, error) {
+	iv, err := patternInterval(from, to)
+	if err != nil {
+		return Ranges{}, err
+	}
+	return Ranges{intervals: []interval{iv}}, nil
+}
+
+// AroundRange returns the range that includes line target, along with before
+// lines preceding it and after lines following it. Line target is selected
+// prefixed with marker, and every other line selected by the same Ranges is
+// prefixed with spaces as wide as marker.
+func AroundRange(target, before, after int, marker string) (Ranges, error) {
+	iv, err := aroundInterval(target, before, after, marker)
+	if err != nil {
+		return Ranges{}, err
+	}
+	return Ranges{intervals: []interval{iv}}, nil
+}
+
+// Union returns ranges that select the lines either rs or other select.
+func (rs Ranges) Union(other Ranges) Ranges {
+	intervals := append(append([]interval(nil), rs.intervals...), other.intervals...)
+	rs.intervals = normalizeIntervals(intervals)
+	return rs
+}
+
+// WithPatternOptions returns a copy of rs in which, when exclusive is true, the
+// lines that match the patterns that begin and end each range are not
+// selected, and when repeat is true, each range that begins with a pattern
+// begins again each time the pattern matches after the range ends.
+func (rs Ranges) WithPatternOptions(exclusive, repeat bool) Ranges {
+	rs.intervals = append([]interval(nil), rs.intervals...)
+	setPatternOptions(rs.intervals, exclusive, repeat)
+	return rs
+}
+
+// Invert returns a copy of rs that selects only the lines rs does not select.
+// Inverted ranges never mark lines.
+func (rs Ranges) Invert() Ranges {
+	rs.invert = !rs.invert
+	return rs
+}
+
+// HasPercent returns true when any of the ranges is addressed by a percentage,
+// which must be resolved by Resolve before rs may select lines.
+func (rs Ranges) HasPercent() bool {
+	return hasPercent(rs.intervals)
+}
+
+// Resolve returns a copy of rs with each of its percentage addresses resolved
+// to a line number for an input that has total lines. A percentage START
+// resolves to the line that follows the initial START percent of the lines,
+// and a percentage END resolves to the final line of the initial END percent of
+// the lines, each rounding down to a whole number of lines.
+func (rs Ranges) Resolve(total int) Ranges {
+	rs.intervals = resolvePercentages(rs.intervals, total)
+	return rs
+}
+
+// IsAbsolute returns true when the lines the ranges select depend only on their
+// line numbers counted from the start of the input, and not on a pattern, a
+// percentage, or the number of lines in the input.
+func (rs Ranges) IsAbsolute() bool {
+	for _, iv := range rs.intervals {
+		if !iv.isAbsolute() {
+			return false
+		}
+	}
+	return true
+}
+
+// First returns the line number of the first line the ranges may select, so
+// that an input may skip the lines preceding it, such as by seeking past them,
+// then use Seek. It returns 1 when any range is relative to the end of the
+// input, or begins at a pattern.
+func (rs Ranges) First() int {
+	if rs.invert {
+		return 1
+	}
+	if n := firstLine(rs.intervals) - rs.offset; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// Seek returns a copy of rs for selecting lines from an input that begins after
+// the initial n lines of the input the ranges address, which were skipped.
+func (rs Ranges) Seek(n int) Ranges {
+	rs.offset += n
+	return rs
+}
+
+// Match returns line, marked as described by AroundRange, along with true when
+// rs selects the line with the specified line number, or false otherwise. Match
+// may only be used when IsAbsolute returns true, because other ranges depend on
+// the lines that precede the line, or on the lines that follow it.
+func (rs Ranges) Match(lineNumber int, line []byte) ([]byte, bool) {
+	lineNumber += rs.offset
+	var included bool
+	for _, iv := range rs.intervals {
+		if iv.includes(lineNumber, line, 0) {
+			included = true
+		}
+	}
+	if included == rs.invert {
+		return nil, false
+	}
+	if !rs.invert {
+		line = markLine(rs.intervals, lineNumber, line)
+	}
+	return line, true
+}
+
+func (rs Ranges) start(emit emitFunc) (selection, error) {
+	if rs.HasPercent() {
+		return nil, ErrUnresolved
+	}
+	if len(rs.intervals) == 0 && !rs.invert {
+		return nil, nil
+	}
+
+	// Pattern ranges change state as lines are read, so must not share state
+	// with those used for any other input.
+	intervals := append([]interval(nil), rs.intervals...)
+
+	if n := lookBehind(intervals); n > 0 {
+		return &relativeSelection{emit: emit, intervals: intervals, invert: rs.invert, offset: rs.offset, behind: n, buffer: newLineRing(n)}, nil
+	}
+
+	return &rangeSelection{emit: emit, intervals: intervals, invert: rs.invert, offset: rs.offset, last: finalLine(intervals)}, nil
+}
+
+// rangeSelection selects the lines within intervals, or when invert is true,
+// only the lines that are not. When every interval is bounded by absolute line
+// numbers, it selects no further lines after the final line of the final
+// interval, or when invert is true, merely stops checking whether lines fall
+// within the intervals.
+type rangeSelection struct {
+	emit      emitFunc
+	intervals []interval
+	invert    bool
+	offset    int
+	last      int  // final line of the final interval, or 0
+	rest      bool // true after last, when every remaining line is selected
+}
+
+func (rs *rangeSelection) line(n, lineNumber int, line []byte) (bool, error) {
+	if rs.rest {
+		return true, rs.emit(lineNumber, line)
+	}
+
+	n += rs.offset
+
+	if includesLine(rs.intervals, n, line, 0) != rs.invert {
+		if !rs.invert {
+			line = markLine(rs.intervals, n, line)
+		}
+		if err := rs.emit(lineNumber, line); err != nil {
+			return false, err
+		}
+	}
+
+	if n == rs.last {
+		if !rs.invert {
+			return false, nil
+		}
+		// Every remaining line falls outside the intervals.
+		rs.rest = true
+	}
+
+	return true, nil
+}
+
+func (rs *rangeSelection) end() error { return nil }
+
+// relativeSelection selects the lines within intervals, or when invert is true,
+// only the lines that are not, when at least one of the intervals is relative
+// to the end of the input. Only the final behind lines are held in memory,
+// where behind is the magnitude of the largest end-relative value.
+type relativeSelection struct {
+	emit      emitFunc
+	intervals []interval
+	invert    bool
+	offset    int
+	behind    int
+	buffer    *lineRing // circular buffer of the final behind lines
+	count     int       // number of lines presented thus far
+}
+
+func (rs *relativeSelection) line(n, lineNumber int, line []byte) (bool, error) {
+	// Use a circular buffer, so we are processing the Nth previous line, which
+	// is known to have at least N lines after it.
+	rs.count = n + rs.offset
+
+	if rs.buffer.full() {
+		held := rs.buffer.oldest()
+		if n = rs.count - rs.behind; includesLine(rs.intervals, n, held.text, 0) != rs.invert {
+			text := held.text
+			if !rs.invert {
+				text = markLine(rs.intervals, n, text)
+			}
+			if err := rs.emit(held.lineNumber, text); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	rs.buffer.push(lineNumber, line)
+	return true, nil
+}
+
+func (rs *relativeSelection) end() error {
+	// Now that the total number of lines is known, the end-relative values can
+	// be resolved for the lines remaining in the circular buffer.
+	n := rs.count - rs.behind // line preceding the lines in the buffer
+	if n < rs.offset {
+		n = rs.offset
+	}
+
+	return rs.buffer.drain(func(lineNumber int, line []byte) error {
+		n++
+		if includesLine(rs.intervals, n, line, rs.count) == rs.invert {
+			return nil
+		}
+		if !rs.invert {
+			line = markLine(rs.intervals, n, line)
+		}
+		return rs.emit(lineNumber, line)
+	})
+}
+
+func (rs *relativeSelection) held() (int, bool) { return rs.buffer.held() }
+
+// includesLine returns true when any of the intervals includes the specified
+// line. Every interval is presented with every line, even after one of them
+// includes it, so that each pattern range sees the entire input.
+func includesLine(intervals []interval, lineNumber int, line []byte, total int) bool {
+	var included bool
+	for i := range intervals {
+		if intervals[i].includes(lineNumber, line, total) {
+			included = true
+		}
+	}
+	return included
+}
+
+// interval represents an inclusive range of line numbers to select. A start
+// value of 0 means the range begins with the first line of the input, and an
+// end value of 0 means the range continues thru the final line of the input.
+// Negative values are relative to the end of the input, so -1 is the final
+// line, -2 is the line before the final line, and so on. When step is greater
+// than 1, only every step-th line of the interval is selected, beginning with
+// its first line.
+//
+// When first is not nil, the interval begins with the next line that matches
+// it rather than at start, and when last is not nil, the interval ends with the
+// next line after the beginning line that matches it rather than at end. When
+// count is greater than 0, the interval ends after count lines rather than at
+// end. Because such intervals depend on the lines read thus far, they must be
+// presented with every line of the input, in order.
+type interval struct {
+	start, end  int
+	step        int
+	first, last *regexp.Regexp
+	count       int
+
+	exclusive bool // omit lines matched by first and last
+	repeat    bool // begin again each time first matches after interval ends
+
+	target int    // line number to mark with marker, or 0
+	marker string // prefix for target line
+
+	startPercent, endPercent *big.Rat // when not nil, replace start and end
+
+	active bool // true after interval began, but before it ended
+	done   bool // true after interval ended and will not begin again
+	began  int  // line number that began the interval
+}
+
+// isAbsolute returns true when the lines the interval includes depend only on
+// their line numbers counted from the start of the input.
+func (iv interval) isAbsolute() bool {
+	return iv.start >= 0 && iv.end >= 0 && !iv.isStateful() && !iv.isPercent()
+}
+
+// isPercent returns true when either end of the interval is a percentage of the
+// lines in the input, which must be resolved by resolvePercentages before the
+// interval is used.
+func (iv interval) isPercent() bool {
+	return iv.startPercent != nil || iv.endPercent != nil
+}
+
+// isStateful returns true when the lines the interval includes depend on the
+// lines previously presented to it.
+func (iv interval) isStateful() bool {
+	return iv.first != nil || iv.last != nil || iv.count > 0
+}
+
+// includes returns true when the interval includes the specified line number,
+// whose text is line. When total is 0, the total number of lines in the input
+// is not yet known, but the line is known to be followed by more lines than the
+// magnitude of any end-relative value in the interval. Otherwise total is the
+// number of lines in the input.
+func (iv *interval) includes(lineNumber int, line []byte, total int) bool {
+	if iv.isStateful() {
+		return iv.matches(lineNumber, line, total)
+	}
+
+	start, end := iv.start, iv.end
+
+	if start < 0 {
+		if total == 0 {
+			return false // line is before the start of the interval
+		}
+		start += total + 1
+	}
+
+	if end < 0 {
+		if total == 0 {
+			end = 0 // line is before the end of the interval
+		} else if end += total + 1; end < 1 {
+			return false // interval ends before the first line
+		}
+	}
+
+	if lineNumber < start || (end > 0 && lineNumber > end) {
+		return false
+	}
+
+	if iv.step > 1 {
+		if start < 1 {
+			start = 1
+		}
+		return (lineNumber-start)%iv.step == 0
+	}
+
+	return true
+}
+
+// matches returns true when a stateful interval includes the specified line,
+// updating the state of the interval. Similar to sed, the line that ends the
+// interval is only checked starting with the line after the line that began
+// it, and when the interval ends at a line number that is not after the line
+// that began it, only the beginning line is included.
+func (iv *interval) matches(lineNumber int, line []byte, total int) bool {
+	if iv.done {
+		return false
+	}
+
+	if !iv.active {
+		if !iv.begins(lineNumber, line, total) {
+			return false
+		}
+		iv.active, iv.began = true, lineNumber
+		if iv.count == 1 || iv.endsAt(lineNumber, total) {
+			iv.finish()
+		}
+		return !(iv.exclusive && iv.first != nil)
+	}
+
+	var matchedLast bool
+
+	switch {
+	case iv.last != nil:
+		matchedLast = iv.last.Match(line)
+		if matchedLast {
+			iv.finish()
+		}
+	case iv.count > 0:
+		if lineNumber-iv.began+1 >= iv.count {
+			iv.finish()
+		}
+	default:
+		if iv.endsAt(lineNumber, total) {
+			iv.finish()
+		}
+	}
+
+	if matchedLast && iv.exclusive {
+		return false
+	}
+
+	return iv.step < 2 || (lineNumber-iv.began)%iv.step == 0
+}
+
+// begins returns true when the specified line begins a stateful interval.
+func (iv *interval) begins(lineNumber int, line []byte, total int) bool {
+	if iv.first != nil {
+		return iv.first.Match(line)
+	}
+
+	start := iv.start
+	if start < 0 {
+		if total == 0 {
+			return false // line is before the start of the interval
+		}
+		start += total + 1
+	}
+
+	return lineNumber >= start
+}
+
+// endsAt returns true when a stateful interval that ends at a line number
+// rather than at a pattern or after a count ends at or before the specified
+// line.
+func (iv *interval) endsAt(lineNumber int, total int) bool {
+	if iv.last != nil || iv.count > 0 {
+		return false
+	}
+
+	end := iv.end
+	if end < 0 {
+		if total == 0 {
+			return false // line is before the end of the interval
+		}
+		end += total + 1
+	}
+
+	return end != 0 && lineNumber >= end
+}
+
+// finish ends a stateful interval, allowing it to begin again when it both
+// begins with a pattern and repeats.
+func (iv *interval) finish() {
+	iv.active = false
+	iv.done = !(iv.repeat && iv.first != nil)
+}
+
+// finalLine returns the largest line number included by any of the provided
+// intervals, or 0 when any interval continues thru the final line of the input,
+// is relative to the end of the input, or begins at a pattern.
+func finalLine(intervals []interval) int {
+	var n int
+	for _, iv := range intervals {
+		if iv.end == 0 || !iv.isAbsolute() {
+			return 0
+		}
+		if iv.end > n {
+			n = iv.end
+		}
+	}
+	return n
+}
+
+// firstLine returns the smallest line number included by any of the provided
+// intervals, or 1 when any interval is relative to the end of the input, or
+// begins at a pattern.
+func firstLine(intervals []interval) int {
+	n := maxInt
+	for _, iv := range intervals {
+		if iv.start < 1 || !iv.isAbsolute() {
+			return 1
+		}
+		if iv.start < n {
+			n = iv.start
+		}
+	}
+	if n == maxInt {
+		return 1
+	}
+	return n
+}
+
+// lookBehind returns the number of lines that must be held in memory to
+// resolve every end-relative value in the provided intervals.
+func lookBehind(intervals []interval) int {
+	var n int
+	for _, iv := range intervals {
+		if iv.start < -n {
+			n = -iv.start
+		}
+		if iv.end < -n {
+			n = -iv.end
+		}
+	}
+	return n
+}
+
+// markLine returns line prefixed with the marker of the interval that targets
+// its line number, or with spaces as wide as that marker when line is not the
+// target of any interval. When none of the intervals have a target, it returns
+// line unmodified.
+func markLine(intervals []interval, lineNumber int, line []byte) []byte {
+	var padding string
+	for _, iv := range intervals {
+		if iv.target == 0 {
+			continue
+		}
+		if iv.target == lineNumber {
+			return append([]byte(iv.marker), line...)
+		}
+		if padding == "" {
+			padding = strings.Repeat(" ", utf8.RuneCountInString(iv.marker))
+		}
+	}
+	if padding == "" {
+		return line
+	}
+	return append([]byte(padding), line...)
+}
+
+// aroundInterval returns the interval that includes the target line, along
+// with before lines preceding it and after lines following it, and that marks
+// the target line with marker.
+func aroundInterval(target, before, after int, marker string) (interval, error) {
+	if target < 1 {
+		return interval{}, parseError(ErrInvalidRange, "cannot print lines around line %d.", target)
+	}
+	if target > maxInt-after {
+		return interval{}, parseError(ErrInvalidRange, "cannot print %d lines after line %d because the final line number is too large.", after, target)
+	}
+
+	iv := interval{start: target - before, end: target + after, target: target, marker: marker}
+	if iv.start < 1 {
+		iv.start = 1
+	}
+
+	return iv, nil
+}
+
+// hasPercent returns true when any of the intervals has a percentage address.
+func hasPercent(intervals []interval) bool {
+	for _, iv := range intervals {
+		if iv.isPercent() {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePercentages returns a copy of the provided intervals, with each of
+// their percentage addresses resolved to a line number for an input that has
+// total lines. A percentage START resolves to the line that follows the initial
+// START percent of the lines, and a percentage END resolves to the final line
+// of the initial END percent of the lines, each rounding down to a whole number
+// of lines, so 40%-60% of 10 lines resolves to lines 5 thru 6, and 0%-100%
+// resolves to every line.
+func resolvePercentages(intervals []interval, total int) []interval {
+	resolved := make([]interval, len(intervals))
+
+	for i, iv := range intervals {
+		if iv.startPercent != nil {
+			iv.start = percentOf(iv.startPercent, total) + 1
+			iv.startPercent = nil
+		}
+		if iv.endPercent != nil {
+			if iv.end = percentOf(iv.endPercent, total); iv.end == 0 {
+				if iv.isStateful() {
+					iv.end = 1 // only the line that begins the interval
+				} else {
+					iv.start, iv.end = total+1, total+1 // no lines
+				}
+			}
+			iv.endPercent = nil
+		}
+		resolved[i] = iv
+	}
+
+	return normalizeIntervals(resolved)
+}
+
+// setPatternOptions sets whether the lines matched by the patterns of each of
+// the intervals are selected, and whether each interval that begins with a
+// pattern begins again after it ends.
+func setPatternOptions(intervals []interval, exclusive, repeat bool) {
+	for i := range intervals {
+		intervals[i].exclusive = exclusive
+		intervals[i].repeat = intervals[i].repeat || repeat
+	}
+}
+
+// patternInterval returns the interval that begins with the first line that
+// matches from, and ends with the following line that matches to. When from is
+// empty the interval begins with the first line of the input, and when to is
+// empty the interval continues thru the final line of the input.
+func patternInterval(from, to string) (interval, error) {
+	var iv interval
+	var err error
+
+	if from != "" {
+		if iv.first, err = compilePattern(from); err != nil {
+			return iv, err
+		}
+	}
+
+	if to != "" {
+		if iv.last, err = compilePattern(to); err != nil {
+			return iv, err
+		}
+	}
+
+	return iv, nil
+}
+
+// compilePattern compiles the regular expression of a pattern address.
+func compilePattern(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, parseError(ErrInvalidPattern, "cannot use empty pattern.")
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, parseError(ErrInvalidPattern, "cannot compile pattern %q: %s.", s, err)
+	}
+	return re, nil
+}
+
+// parseRanges parses a comma separated list of ranges, where each range is
+// either a single address N, START-END, START:END, START+COUNT, or
+// /PATTERN/,END, where
+// either START or END may be omitted, and where any range may be followed by
+// ~STEP. It returns the intervals with the absolute intervals sorted by
+// starting line number, and with overlapping and adjacent absolute intervals
+// merged.
+func parseRanges(s string) ([]interval, error) {
+	var intervals []interval
+
+	items, err := splitRanges(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		iv, err := parseInterval(item.text, item.patterns)
+		if err != nil {
+			if len(item.patterns) > 0 {
+				// Error would display pattern marks rather than patterns.
+				return nil, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", item.source)
+			}
+			return nil, err
+		}
+		intervals = append(intervals, iv)
+	}
+
+	return normalizeIntervals(intervals), nil
+}
+
+// rangeItem is a single range from a list of ranges, with each of its
+// /PATTERN/ addresses replaced by patternMark in text, and compiled into
+// patterns, in the order they appear.
+type rangeItem struct {
+	source   string
+	text     string
+	patterns []*regexp.Regexp
+}
+
+// splitRanges splits a comma separated list of ranges into its individual
+// ranges. A comma inside a /PATTERN/ address does not end a range, nor does a
+// comma that immediately follows a range that consists only of a /PATTERN/
+// address, so that /START/,/END/ and /START/,+N have the same meaning they do
+// for sed. A slash may be included in a pattern by escaping it with a
+// backslash.
+func splitRanges(s string) ([]rangeItem, error) {
+	var items []rangeItem
+	var item rangeItem
+	var text, pattern strings.Builder
+	var inPattern bool
+	var begin int
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if inPattern {
+			switch {
+			case c == '\\' && i+1 < len(s) && s[i+1] == '/':
+				pattern.WriteByte('/')
+				i++
+			case c == '/':
+				re, err := compilePattern(pattern.String())
+				if err != nil {
+					return nil, err
+				}
+				item.patterns = append(item.patterns, re)
+				text.WriteString(patternMark)
+				pattern.Reset()
+				inPattern = false
+			default:
+				pattern.WriteByte(c)
+			}
+			continue
+		}
+
+		switch {
+		case c == '/':
+			inPattern = true
+		case c == ',' && text.String() == patternMark:
+			text.WriteByte(c) // sed style separator after beginning pattern
+		case c == ',':
+			item.source, item.text = s[begin:i], text.String()
+			items = append(items, item)
+			item = rangeItem{}
+			text.Reset()
+			begin = i + 1
+		default:
+			text.WriteByte(c)
+		}
+	}
+
+	if inPattern {
+		return nil, parseError(ErrInvalidRange, "cannot find end of pattern in range: %q.", s)
+	}
+
+	item.source, item.text = s[begin:], text.String()
+	return append(items, item), nil
+}
+
+// parseInterval parses a single range of lines, either N, START-END,
+// START:END, START+COUNT, or /PATTERN/,END, optionally followed by ~STEP. N~STEP selects
+// every STEP-th line starting at line N, and ~STEP alone selects every STEP-th
+// line of the input. When given a single /PATTERN/, every line that matches it
+// is selected.
+func parseInterval(s string, patterns []*regexp.Regexp) (interval, error) {
+	var iv interval
+	var err error
+
+	body, step := s, ""
+	if i := strings.IndexByte(s, '~'); i >= 0 {
+		body, step = s[:i], s[i+1:]
+	}
+
+	p := &addressParser{patterns: patterns}
+
+	switch {
+	case strings.HasPrefix(body, patternMark+","):
+		iv, err = p.parseSedInterval(body)
+	case strings.Contains(body, "+"):
+		iv, err = p.parseCountInterval(body)
+	case strings.Contains(body, ":"):
+		iv, err = p.parseSliceInterval(body)
+	case body == "" && step != "":
+		// whole input
+	default:
+		iv, err = p.parseLineInterval(body)
+		if err == nil && step != "" && !strings.Contains(body, "-") {
+			iv.end, iv.count = 0, 0 // N~STEP continues thru the final line of the input
+		}
+	}
+	if err != nil {
+		return iv, err
+	}
+
+	if step != "" {
+		if iv.step != 0 {
+			return iv, parseError(ErrInvalidRange, "cannot print range of lines with more than one step: %q.", s)
+		}
+		if iv.step, err = parseStep(step); err != nil {
+			return iv, err
+		}
+	}
+
+	return iv, nil
+}
+
+// addressParser parses the addresses of a single range, handing out its
+// compiled patterns in the order their marks are found.
+type addressParser struct {
+	patterns []*regexp.Regexp
+}
+
+// parseStart parses the initial address of a range, storing it in iv.
+func (p *addressParser) parseStart(iv *interval, a string) error {
+	if a == patternMark {
+		iv.first, p.patterns = p.patterns[0], p.patterns[1:]
+		return nil
+	}
+	if strings.HasSuffix(a, "%") {
+		var err error
+		iv.startPercent, err = parsePercent(a)
+		return err
+	}
+	if a != "" {
+		var err error
+		if iv.start, err = strconv.Atoi(a); err != nil {
+			return numberError("initial value", a, err)
+		}
+	}
+	return nil
+}
+
+// parseEnd parses the final address of a range, storing it in iv.
+func (p *addressParser) parseEnd(iv *interval, a string) error {
+	if a == patternMark {
+		iv.last, p.patterns = p.patterns[0], p.patterns[1:]
+		return nil
+	}
+	if strings.HasSuffix(a, "%") {
+		var err error
+		iv.endPercent, err = parsePercent(a)
+		return err
+	}
+	if a != "" {
+		var err error
+		if iv.end, err = strconv.Atoi(a); err != nil {
+			return numberError("final value", a, err)
+		}
+	}
+	return nil
+}
+
+// parseSedInterval parses a single range of lines in the form /PATTERN/,END,
+// where END is either a line number, a pattern, or +N to include the N lines
+// following the line that matches the initial pattern.
+func (p *addressParser) parseSedInterval(s string) (interval, error) {
+	var iv interval
+
+	if err := p.parseStart(&iv, patternMark); err != nil {
+		return iv, err
+	}
+
+	a := s[len(patternMark)+1:]
+
+	if strings.HasPrefix(a, "+") {
+		n, err := strconv.Atoi(a[1:])
+		if err != nil || n < 0 {
+			return iv, parseError(ErrInvalidRange, "cannot parse final value from range: %q.", a)
+		}
+		iv.count = n + 1
+		return iv, nil
+	}
+
+	if a == "" {
+		return iv, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", s)
+	}
+
+	return iv, p.parseEnd(&iv, a)
+}
+
+// parseCountInterval parses a single range of lines in the form START+COUNT,
+// which includes COUNT lines beginning with START, where START may be omitted
+// to begin with the first line of the input.
+func (p *addressParser) parseCountInterval(s string) (interval, error) {
+	var iv interval
+
+	lines := strings.Split(s, "+")
+	if len(lines) != 2 {
+		return iv, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", s)
+	}
+
+	if err := p.parseStart(&iv, lines[0]); err != nil {
+		return iv, err
+	}
+	if iv.startPercent != nil {
+		return iv, parseError(ErrInvalidRange, "cannot use percentage with count in range: %q.", s)
+	}
+
+	count, err := strconv.Atoi(lines[1])
+	if err != nil {
+		return iv, numberError("count", lines[1], err)
+	}
+	if count < 1 {
+		return iv, parseError(ErrInvalidRange, "cannot print range of lines with count less than 1: %q.", s)
+	}
+
+	if iv.first != nil || iv.start < 0 {
+		// Final line number is not known until the range begins.
+		iv.count = count
+		return iv, nil
+	}
+
+	if iv.start == 0 {
+		iv.start = 1
+	}
+	if iv.start > maxInt-count+1 {
+		return iv, parseError(ErrInvalidRange, "cannot print %d lines starting with line %d because the final line number is too large.", count, iv.start)
+	}
+	iv.end = iv.start + count - 1
+
+	return iv, nil
+}
+
+// parseLineInterval parses a single range of lines, either N or START-END.
+func (p *addressParser) parseLineInterval(s string) (interval, error) {
+	var iv interval
+
+	switch lines := strings.Split(s, "-"); len(lines) {
+	case 1:
+		a := lines[0]
+		if a == "" {
+			return iv, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", s)
+		}
+		if a == patternMark {
+			// When given a single pattern for a range, print every line that
+			// matches it.
+			iv.first, iv.count, iv.repeat = p.patterns[0], 1, true
+			return iv, nil
+		}
+		if err := p.parseStart(&iv, a); err != nil {
+			return iv, err
+		}
+		if iv.startPercent != nil {
+			iv.count = 1 // only print the line a single percentage resolves to
+			return iv, nil
+		}
+		iv.end = iv.start // when given a single number for a range, only print that line number
+	case 2:
+		if err := p.parseStart(&iv, lines[0]); err != nil {
+			return iv, err
+		}
+		if err := p.parseEnd(&iv, lines[1]); err != nil {
+			return iv, err
+		}
+		if iv.end > 0 && iv.start > iv.end {
+			return iv, parseError(ErrInvalidRange, "cannot print lines %d thru %d because they are out of order.", iv.start, iv.end)
+		}
+	default:
+		return iv, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", s)
+	}
+
+	return iv, nil
+}
+
+// parseSliceInterval parses a single range of lines in the form START:END or
+// START:END:STEP, where any of the values may be omitted, and START or END may
+// be negative to address lines relative to the end of the input.
+func (p *addressParser) parseSliceInterval(s string) (interval, error) {
+	var iv interval
+	var err error
+
+	lines := strings.Split(s, ":")
+	switch len(lines) {
+	case 2:
+	case 3:
+		if a := lines[2]; a != "" {
+			if iv.step, err = parseStep(a); err != nil {
+				return iv, err
+			}
+		}
+	default:
+		return iv, parseError(ErrInvalidRange, "cannot print invalid range of lines: %q.", s)
+	}
+
+	if err = p.parseStart(&iv, lines[0]); err != nil {
+		return iv, err
+	}
+	if err = p.parseEnd(&iv, lines[1]); err != nil {
+		return iv, err
+	}
+
+	// Order can only be verified when both values count from the same end of
+	// the input.
+	if (iv.start > 0 && iv.end > 0 || iv.start < 0 && iv.end < 0) && iv.start > iv.end {
+		return iv, parseError(ErrInvalidRange, "cannot print lines %d thru %d because they are out of order.", iv.start, iv.end)
+	}
+
+	return iv, nil
+}
+
+// numberError returns an error describing why the named value of a range
+// could not be parsed.
+func numberError(name, a string, err error) error {
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+		return parseError(ErrInvalidRange, "cannot parse %s from range because it is too large: %q.", name, a)
+	}
+	return parseError(ErrInvalidRange, "cannot parse %s from range: %q.", name, a)
+}
+
+// parseStep parses the step value of a range, which must be a positive
+// integer.
+func parseStep(s string) (int, error) {
+	step, err := strconv.Atoi(s)
+	if err != nil || step < 1 {
+		return 0, parseError(ErrInvalidRange, "cannot parse step value from range: %q.", s)
+	}
+	return step, nil
+}
+
+// normalizeIntervals sorts the provided intervals by their starting line
+// number, and merges any intervals that overlap or abut one another, so that
+// each line number is covered by at most one interval. Intervals that are not
+// absolute cannot be compared with other intervals until the input has been
+// read, so they are placed after the absolute intervals, in the order given,
+// and are never merged. Likewise intervals with a step or a target line are
+// never merged.
+func normalizeIntervals(intervals []interval) []interval {
+	if len(intervals) < 2 {
+		return intervals
+	}
+
+	sort.SliceStable(intervals, func(i, j int) bool {
+		if a, b := intervals[i].isAbsolute(), intervals[j].isAbsolute(); !a || !b {
+			return a && !b
+		}
+		return intervals[i].start < intervals[j].start
+	})
+
+	merged := intervals[:1]
+
+	for _, iv := range intervals[1:] {
+		prev := &merged[len(merged)-1]
+
+		if !prev.isAbsolute() || !iv.isAbsolute() || prev.step > 1 || iv.step > 1 || prev.target > 0 || iv.target > 0 || prev.end > 0 && iv.start > prev.end+1 {
+			merged = append(merged, iv)
+			continue
+		}
+
+		// Intervals overlap or abut: extend previous interval to cover both.
+		if prev.end > 0 && (iv.end == 0 || iv.end > prev.end) {
+			prev.end = iv.end
+		}
+	}
+
+	return merged
+}
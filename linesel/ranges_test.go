@@ -0,0 +1,489 @@
+package linesel
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rangeCase is a range expression, along with the lines it selects from an
+// input of n numbered lines, joined by commas, or the error it is parsed with.
+type rangeCase struct {
+	expr string
+	n    int
+	want string
+	err  error
+}
+
+// testRanges verifies the lines selected by each of the range expressions.
+func testRanges(t *testing.T, cases []rangeCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			rs, err := ParseRanges(tc.expr)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("GOT: %v; WANT: %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := copyString(t, rs, numberedLines(tc.n)); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// countingScanner is a Scanner that counts the lines it scans.
+type countingScanner struct {
+	*bufio.Scanner
+	count int
+}
+
+func (cs *countingScanner) Scan() bool {
+	if !cs.Scanner.Scan() {
+		return false
+	}
+	cs.count++
+	return true
+}
+
+// scannedLines returns the number of lines of an input of n numbered lines
+// scanned while sel selects lines from it.
+func scannedLines(t *testing.T, sel Selector, n int) int {
+	t.Helper()
+	cs := &countingScanner{Scanner: bufio.NewScanner(strings.NewReader(numberedLines(n)))}
+	if err := Select(&strings.Builder{}, cs, sel); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	return cs.count
+}
+
+func TestParseRangesLists(t *testing.T) {
+	testRanges(t, []rangeCase{
+		{expr: "3", n: 10, want: "3"},
+		{expr: "3-5", n: 10, want: "3,4,5"},
+		{expr: "-3", n: 10, want: "1,2,3"},
+		{expr: "8-", n: 10, want: "8,9,10"},
+		{expr: "1-3,7,9-", n: 10, want: "1,2,3,7,9,10"},
+		{expr: "9-,1-3,7", n: 10, want: "1,2,3,7,9,10"},
+		{expr: "1-3,2-5", n: 10, want: "1,2,3,4,5"},
+		{expr: "1-3,4-5", n: 10, want: "1,2,3,4,5"},
+		{expr: "2,2,2", n: 10, want: "2"},
+		{expr: "4-6,1-", n: 6, want: "1,2,3,4,5,6"},
+		{expr: "5-9", n: 6, want: "5,6"},
+		{expr: "8", n: 6, want: ""},
+		{expr: "1-3", n: 0, want: ""},
+		{expr: "", err: ErrInvalidRange},
+		{expr: "1,,3", err: ErrInvalidRange},
+		{expr: "1,", err: ErrInvalidRange},
+		{expr: "5-3", err: ErrInvalidRange},
+		{expr: "1-2-3", err: ErrInvalidRange},
+		{expr: "a-3", err: ErrInvalidRange},
+		{expr: "1-b", err: ErrInvalidRange},
+		{expr: "99999999999999999999", err: ErrInvalidRange},
+		{expr: "1\x002", err: ErrInvalidRange},
+	})
+}
+
+func TestNormalizeIntervals(t *testing.T) {
+	cases := []struct {
+		expr string
+		want [][2]int
+	}{
+		{"7,1-3", [][2]int{{1, 3}, {7, 7}}},
+		{"1-3,2-5", [][2]int{{1, 5}}},
+		{"1-3,4-5", [][2]int{{1, 5}}},
+		{"1-3,5-6", [][2]int{{1, 3}, {5, 6}}},
+		{"2-4,1-", [][2]int{{1, 0}}},
+		{"5-,1-2,3-8", [][2]int{{1, 0}}},
+		{"1-10,3-4", [][2]int{{1, 10}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			rs, err := ParseRanges(tc.expr)
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			var got [][2]int
+			for _, iv := range rs.intervals {
+				got = append(got, [2]int{iv.start, iv.end})
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("GOT: %v; WANT: %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("GOT: %v; WANT: %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRangesStopReading(t *testing.T) {
+	cases := []struct {
+		expr string
+		want int
+	}{
+		{"3", 3},
+		{"1-3,7", 7},
+		{"7,1-3", 7},
+		{"2-4,6-8", 8},
+		{"1-3,9-", 100},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			rs, err := ParseRanges(tc.expr)
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if got := scannedLines(t, rs, 100); got != tc.want {
+				t.Errorf("GOT: %d lines scanned; WANT: %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRangesZeroValueSelectsNothing(t *testing.T) {
+	if got := copyString(t, Ranges{}, numberedLines(5)); got != "" {
+		t.Errorf("GOT: %q; WANT: %q", got, "")
+	}
+}
+
+func TestParseRangesEndRelative(t *testing.T) {
+	testRanges(t, []rangeCase{
+		{expr: "5:-3", n: 10, want: "5,6,7,8"},
+		{expr: "-3:", n: 10, want: "8,9,10"},
+		{expr: "-10:-2", n: 10, want: "1,2,3,4,5,6,7,8,9"},
+		{expr: "-10:-2", n: 5, want: "1,2,3,4"},
+		{expr: ":-8", n: 10, want: "1,2,3"},
+		{expr: "-1:", n: 10, want: "10"},
+		{expr: "-1:-1", n: 10, want: "10"},
+		{expr: "3:", n: 5, want: "3,4,5"},
+		{expr: ":", n: 3, want: "1,2,3"},
+		{expr: "2:4", n: 10, want: "2,3,4"},
+		{expr: "-3:2", n: 4, want: "2"},
+		{expr: "-3:2", n: 10, want: ""},
+		{expr: "5:-3", n: 6, want: ""},
+		{expr: ":-20", n: 10, want: ""},
+		{expr: "1,-2:", n: 5, want: "1,4,5"},
+		{expr: "1-2,-2:", n: 3, want: "1,2,3"},
+		{expr: "-5:", n: 0, want: ""},
+		{expr: "-1:-3", err: ErrInvalidRange},
+		{expr: "5:3", err: ErrInvalidRange},
+		{expr: "1:2:3:4", err: ErrInvalidRange},
+		{expr: "-x:", err: ErrInvalidRange},
+	})
+}
+
+func TestEndRelativeRangesHoldOnlyTheirWindow(t *testing.T) {
+	rs, err := ParseRanges("2:-3")
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+
+	var emitted []int
+	st, err := rs.start(func(lineNumber int, _ []byte) error {
+		emitted = append(emitted, lineNumber)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if got := len(st.(*relativeSelection).buffer.items); got != 3 {
+		t.Errorf("GOT: %d lines held; WANT: %d", got, 3)
+	}
+
+	// Each line is emitted as soon as 3 lines follow it.
+	for n := 1; n <= 10; n++ {
+		if _, err = st.line(n, n, []byte("line")); err != nil {
+			t.Fatalf("GOT: %v; WANT: %v", err, nil)
+		}
+		want := n - 4 // lines 2 thru n-3
+		if want < 0 {
+			want = 0
+		}
+		if len(emitted) != want {
+			t.Fatalf("after line %d: GOT: %v; WANT: %d lines", n, emitted, want)
+		}
+	}
+	if err = st.end(); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if got, want := len(emitted), 7; got != want { // lines 2 thru 8
+		t.Errorf("GOT: %v; WANT: %d lines", emitted, want)
+	}
+}
+
+func TestParseRangesSteps(t *testing.T) {
+	testRanges(t, []rangeCase{
+		{expr: "1-10~3", n: 20, want: "1,4,7,10"},
+		{expr: "2-9~3", n: 20, want: "2,5,8"},
+		{expr: "~3", n: 7, want: "1,4,7"},
+		{expr: "::3", n: 7, want: "1,4,7"},
+		{expr: "2:8:3", n: 20, want: "2,5,8"},
+		{expr: "2:8~3", n: 20, want: "2,5,8"},
+		{expr: "5~2", n: 10, want: "5,7,9"},
+		{expr: "-5:~2", n: 10, want: "6,8,10"},
+		{expr: ":-2:4", n: 10, want: "1,5,9"},
+		{expr: "1-3~1", n: 5, want: "1,2,3"},
+		{expr: "1-4~2,2-4~2", n: 5, want: "1,2,3,4"},
+		{expr: "/3/,/7/~2", n: 10, want: "3,5,7"},
+		{expr: "1-5~0", err: ErrInvalidRange},
+		{expr: "1-5~-1", err: ErrInvalidRange},
+		{expr: "1-5~x", err: ErrInvalidRange},
+		{expr: "1:5:2~2", err: ErrInvalidRange},
+		{expr: "1:5:0", err: ErrInvalidRange},
+	})
+}
+
+// blocks is an input with two blocks of lines, each delimited by BEGIN and END.
+const blocks = "a\nBEGIN\nb\nEND\nc\nBEGIN\nd\nEND\ne\n"
+
+func TestParseRangesPatterns(t *testing.T) {
+	cases := []struct {
+		expr  string
+		input string
+		want  string
+		err   error
+	}{
+		{expr: "/BEGIN/,/END/", input: blocks, want: "BEGIN,b,END"},
+		{expr: "/BEGIN/-/END/", input: blocks, want: "BEGIN,b,END"},
+		{expr: "/BEGIN/", input: blocks, want: "BEGIN,BEGIN"},
+		{expr: "/E.D/", input: blocks, want: "END,END"},
+		{expr: "/BEGIN/,+1", input: blocks, want: "BEGIN,b"},
+		{expr: "/BEGIN/,+0", input: blocks, want: "BEGIN"},
+		{expr: "/BEGIN/,3", input: blocks, want: "BEGIN,b"},
+		{expr: "/BEGIN/,1", input: blocks, want: "BEGIN"},
+		{expr: "3-/END/", input: blocks, want: "b,END"},
+		{expr: "/c/-", input: blocks, want: "c,BEGIN,d,END,e"},
+		{expr: "-/b/", input: blocks, want: "a,BEGIN,b"},
+		{expr: "1,/d/", input: blocks, want: "a,d"},
+		{expr: "/B/,/B/", input: "B\nx\nB\ny\n", want: "B,x,B"},
+		{expr: `/a\/b/`, input: "a\na/b\nb\n", want: "a/b"},
+		{expr: "/x,y/", input: "x\nx,y\ny\n", want: "x,y"},
+		{expr: "/nothing/", input: blocks, want: ""},
+		{expr: "/[/", err: ErrInvalidPattern},
+		{expr: "//", err: ErrInvalidPattern},
+		{expr: "/abc", err: ErrInvalidRange},
+		{expr: "/a/,", err: ErrInvalidRange},
+		{expr: "/a/,+x", err: ErrInvalidRange},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			rs, err := ParseRanges(tc.expr)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("GOT: %v; WANT: %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := copyString(t, rs, tc.input); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPatternRange(t *testing.T) {
+	cases := []struct {
+		name              string
+		from, to          string
+		exclusive, repeat bool
+		want              string
+		err               error
+	}{
+		{name: "from and to", from: "BEGIN", to: "END", want: "BEGIN,b,END"},
+		{name: "repeat", from: "BEGIN", to: "END", repeat: true, want: "BEGIN,b,END,BEGIN,d,END"},
+		{name: "exclusive", from: "BEGIN", to: "END", exclusive: true, want: "b"},
+		{name: "exclusive repeat", from: "BEGIN", to: "END", exclusive: true, repeat: true, want: "b,d"},
+		{name: "only to", to: "END", want: "a,BEGIN,b,END"},
+		{name: "only from", from: "^c", want: "c,BEGIN,d,END,e"},
+		{name: "to never matches", from: "BEGIN", to: "nothing", want: "BEGIN,b,END,c,BEGIN,d,END,e"},
+		{name: "invalid from", from: "[", err: ErrInvalidPattern},
+		{name: "invalid to", to: "(", err: ErrInvalidPattern},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := PatternRange(tc.from, tc.to)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("GOT: %v; WANT: %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			rs = rs.WithPatternOptions(tc.exclusive, tc.repeat)
+			if got := copyString(t, rs, blocks); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPatternRangesDoNotShareState(t *testing.T) {
+	rs, err := ParseRanges("/BEGIN/,/END/")
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if got, want := copyString(t, rs, "BEGIN\nx\n"), "BEGIN,x"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+	// The following input begins with the range inactive, even though the
+	// previous input ended within the range.
+	if got, want := copyString(t, rs, "y\nBEGIN\nz\nEND\n"), "BEGIN,z,END"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestParseRangesCounts(t *testing.T) {
+	testRanges(t, []rangeCase{
+		{expr: "3+2", n: 10, want: "3,4"},
+		{expr: "3+1", n: 10, want: "3"},
+		{expr: "+2", n: 10, want: "1,2"},
+		{expr: "9+5", n: 10, want: "9,10"},
+		{expr: "-3+2", n: 10, want: "8,9"},
+		{expr: "/7/+2", n: 10, want: "7,8"},
+		{expr: "/7/+2~2", n: 10, want: "7"},
+		{expr: "1+2,8+2", n: 10, want: "1,2,8,9"},
+		{expr: "3+0", err: ErrInvalidRange},
+		{expr: "3+-1", err: ErrInvalidRange},
+		{expr: "3+x", err: ErrInvalidRange},
+		{expr: "3+2+1", err: ErrInvalidRange},
+		{expr: "50%+2", err: ErrInvalidRange},
+		{expr: "9223372036854775807+2", err: ErrInvalidRange},
+		{expr: "2+99999999999999999999", err: ErrInvalidRange},
+	})
+}
+
+func TestParseRangesCountsTooLarge(t *testing.T) {
+	_, err := ParseRanges("9223372036854775807+2")
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("GOT: %v; WANT: error for final line number too large", err)
+	}
+}
+
+func TestAroundRange(t *testing.T) {
+	cases := []struct {
+		name                  string
+		target, before, after int
+		want                  []string
+		err                   error
+	}{
+		{name: "context", target: 5, before: 1, after: 2, want: []string{"  4", "> 5", "  6", "  7"}},
+		{name: "no context", target: 5, want: []string{"> 5"}},
+		{name: "near start", target: 2, before: 5, want: []string{"  1", "> 2"}},
+		{name: "near end", target: 9, after: 5, want: []string{"> 9", "  10"}},
+		{name: "beyond end", target: 20, before: 1},
+		{name: "zero target", target: 0, err: ErrInvalidRange},
+		{name: "too large", target: maxInt, after: 1, err: ErrInvalidRange},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := AroundRange(tc.target, tc.before, tc.after, "> ")
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("GOT: %v; WANT: %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			var out strings.Builder
+			if err = Copy(&out, strings.NewReader(numberedLines(10)), rs); err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if out.Len() == 0 {
+				got = nil
+			}
+			if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAroundRangeUnion(t *testing.T) {
+	around, err := AroundRange(3, 0, 0, "=>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := ParseRanges("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err = Copy(&out, strings.NewReader(numberedLines(5)), rs.Union(around)); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	// Every line is padded to the width of the marker, and inverted ranges
+	// never mark lines.
+	if got, want := out.String(), "  1\n=>3\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+	out.Reset()
+	if err = Copy(&out, strings.NewReader(numberedLines(5)), rs.Union(around).Invert()); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if got, want := out.String(), "2\n4\n5\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestResolvePercentages(t *testing.T) {
+	cases := []struct {
+		expr  string
+		total int
+		want  string
+	}{
+		{"40%-60%", 10, "5,6"},
+		{"0%-100%", 10, "1,2,3,4,5,6,7,8,9,10"},
+		{"0%-100%", 7, "1,2,3,4,5,6,7"},
+		{"90%-", 10, "10"},
+		{"-10%", 10, "1"},
+		{"-5%", 10, ""},
+		{"50%", 10, "6"},
+		{"50%", 3, "2"},
+		{"100%", 10, ""},
+		{"33.3%-66.7%", 10, "4,5,6"},
+		{"99.5%-", 200, "200"},
+		{"1-2,50%-", 4, "1,2,3,4"},
+		{"/7/,50%", 10, "7"},
+		{"/3/,50%", 10, "3,4,5"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			rs, err := ParseRanges(tc.expr)
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if !rs.HasPercent() {
+				t.Fatalf("HasPercent: GOT: %v; WANT: %v", false, true)
+			}
+			resolved := rs.Resolve(tc.total)
+			if resolved.HasPercent() {
+				t.Fatalf("HasPercent after Resolve: GOT: %v; WANT: %v", true, false)
+			}
+			if got := copyString(t, resolved, numberedLines(tc.total)); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnresolvedPercentage(t *testing.T) {
+	rs, err := ParseRanges("10%-20%")
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if err = Copy(&strings.Builder{}, strings.NewReader("1\n"), rs); !errors.Is(err, ErrUnresolved) {
+		t.Errorf("GOT: %v; WANT: %v", err, ErrUnresolved)
+	}
+}
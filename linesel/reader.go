@@ -0,0 +1,90 @@
+package linesel
+
+import (
+	"io"
+
+	"github.com/karrick/gobls"
+)
+
+// NewReader returns an io.Reader that provides only the lines sel selects from
+// r, each followed by a newline, so that the selected lines may be consumed by
+// anything that reads from an io.Reader, such as a json.Decoder or a
+// csv.Reader:
+//
+//	// Decode the JSON between a three line banner and a one line trailer.
+//	dec := json.NewDecoder(linesel.NewReader(resp.Body, linesel.Skip{Initial: 3, Final: 1}))
+//
+// Lines are read from r only as they are needed to satisfy each Read, and no
+// more lines are held in memory than sel must hold, so the lines of an input of
+// any size are provided in bounded memory. Once sel will select no further
+// lines, no further lines are read from r. An error reading from r is returned
+// by Read after the lines selected before the error have been provided, while
+// an error in sel itself, such as ErrNegative, is returned by the first Read.
+func NewReader(r io.Reader, sel Selector) io.Reader {
+	sr := &reader{scanner: gobls.NewScanner(r)}
+	if sr.state, sr.err = sel.start(sr.emit); sr.state == nil && sr.err == nil {
+		sr.err = io.EOF // selects no lines
+	}
+	return sr
+}
+
+// reader is an io.Reader that provides the lines a selection selects from the
+// lines scanned by scanner.
+type reader struct {
+	scanner gobls.Scanner
+	state   selection
+	buf     []byte // selected lines, each followed by a newline
+	off     int    // offset of the first byte of buf not yet read
+	n       int    // number of lines scanned thus far
+	err     error  // when not nil, returned after every selected line is read
+}
+
+func (sr *reader) Read(p []byte) (int, error) {
+	for sr.off == len(sr.buf) {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		// Reuse the buffer for the lines selected from the next line.
+		sr.buf, sr.off = sr.buf[:0], 0
+		sr.next()
+	}
+
+	n := copy(p, sr.buf[sr.off:])
+	sr.off += n
+	return n, nil
+}
+
+// emit appends a selected line to the buffer.
+func (sr *reader) emit(_ int, line []byte) error {
+	sr.buf = append(append(sr.buf, line...), '\n')
+	return nil
+}
+
+// next presents the next line of the input to the selection, or when there are
+// no more lines, ends the selection.
+func (sr *reader) next() {
+	if !sr.scanner.Scan() {
+		if sr.err = sr.scanner.Err(); sr.err == nil {
+			sr.end()
+		}
+		return
+	}
+
+	sr.n++
+	more, err := sr.state.line(sr.n, sr.n, sr.scanner.Bytes())
+	if err != nil {
+		sr.err = err
+		return
+	}
+	if !more {
+		sr.end()
+	}
+}
+
+// end ends the selection, which may select lines it holds, after which Read
+// returns io.EOF.
+func (sr *reader) end() {
+	if sr.err = sr.state.end(); sr.err == nil {
+		sr.err = io.EOF
+	}
+}
@@ -0,0 +1,91 @@
+package linesel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReaderMatchesCopy(t *testing.T) {
+	ranges, err := ParseRanges("2,4:6,-2:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		sel   Selector
+		input string
+	}{
+		{"top", Top(3), numberedLines(10)},
+		{"top zero", Top(0), numberedLines(10)},
+		{"bottom", Bottom(3), numberedLines(10)},
+		{"skip", Skip{Initial: 2, Final: 2}, numberedLines(10)},
+		{"ranges", ranges, numberedLines(10)},
+		{"invert", Invert(Top(2)), numberedLines(10)},
+		{"selection", Selection{Skip{Initial: 1}, Bottom(4), Top(2)}, numberedLines(10)},
+		{"no final newline", Bottom(2), "1\n2\n3"},
+		{"carriage returns", Top(2), "one\r\ntwo\r\nthree\r\n"},
+		{"empty input", Bottom(2), ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var want bytes.Buffer
+			if err := Copy(&want, strings.NewReader(tc.input), tc.sel); err != nil {
+				t.Fatal(err)
+			}
+
+			// Reading one byte at a time, from an input read one byte at a
+			// time, provides the same lines in the same order.
+			r := NewReader(iotest.OneByteReader(strings.NewReader(tc.input)), tc.sel)
+			got, err := io.ReadAll(iotest.OneByteReader(r))
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if string(got) != want.String() {
+				t.Errorf("GOT: %q; WANT: %q", got, want.String())
+			}
+		})
+	}
+}
+
+func TestReaderStopsReading(t *testing.T) {
+	input := strings.NewReader(numberedLines(100000))
+	got, err := io.ReadAll(NewReader(input, Top(2)))
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if string(got) != "1\n2\n" {
+		t.Errorf("GOT: %q; WANT: %q", got, "1\n2\n")
+	}
+	if input.Len() == 0 {
+		t.Errorf("GOT: entire input read; WANT: input remaining")
+	}
+}
+
+func TestReaderReturnsReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	input := io.MultiReader(strings.NewReader("1\n2\n3\n"), iotest.ErrReader(errRead))
+
+	got, err := io.ReadAll(NewReader(input, Top(2)))
+	if err != nil || string(got) != "1\n2\n" {
+		t.Errorf("before Top is satisfied: GOT: %q, %v; WANT: %q, %v", got, err, "1\n2\n", nil)
+	}
+
+	input = io.MultiReader(strings.NewReader("1\n2\n3\n"), iotest.ErrReader(errRead))
+	got, err = io.ReadAll(NewReader(input, Top(5)))
+	if err != errRead || string(got) != "1\n2\n3\n" {
+		t.Errorf("after selected lines: GOT: %q, %v; WANT: %q, %v", got, err, "1\n2\n3\n", errRead)
+	}
+}
+
+func TestReaderInvalidSelector(t *testing.T) {
+	n, err := NewReader(strings.NewReader("1\n"), Bottom(-1)).Read(make([]byte, 8))
+	if n != 0 || !errors.Is(err, ErrNegative) {
+		t.Errorf("GOT: %d, %v; WANT: %d, %v", n, err, 0, ErrNegative)
+	}
+}
@@ -0,0 +1,133 @@
+package linesel
+
+// arenaSize is the size in bytes of each block of memory from which the storage
+// for the lines held by a lineRing is carved.
+const arenaSize = 64 * 1024
+
+// ring is a circular buffer of the n most recent items stored in it.
+type ring[T any] struct {
+	items  []T
+	index  int  // index of the slot for the next item, which holds the oldest item once looped
+	looped bool // true once every slot holds an item
+}
+
+// newRing returns a circular buffer for n items, where n is greater than 0.
+func newRing[T any](n int) *ring[T] {
+	return &ring[T]{items: make([]T, n)}
+}
+
+// full returns true when the buffer holds n items, so that storing another item
+// replaces the oldest item.
+func (r *ring[T]) full() bool {
+	return r.looped
+}
+
+// next returns the slot for the next item, which holds the oldest item when the
+// buffer is full, then advances past it. The slot is valid until next is invoked
+// n more times.
+func (r *ring[T]) next() *T {
+	slot := &r.items[r.index]
+	if r.index++; r.index == len(r.items) {
+		r.index = 0
+		r.looped = true
+	}
+	return slot
+}
+
+// oldest returns the slot holding the oldest item, which is the slot that will be
+// returned by the following invocation of next when the buffer is full.
+func (r *ring[T]) oldest() *T {
+	return &r.items[r.index]
+}
+
+// each invokes f for the slot of each item in the buffer, from the oldest item
+// to the newest item, stopping at the first error.
+func (r *ring[T]) each(f func(*T) error) error {
+	if r.looped {
+		for i := r.index; i < len(r.items); i++ {
+			if err := f(&r.items[i]); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i < r.index; i++ {
+		if err := f(&r.items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heldLine is a line held by a lineRing, along with its original line number.
+type heldLine struct {
+	lineNumber int
+	text       []byte
+}
+
+// lineRing is a circular buffer of the n most recent lines stored in it, which
+// copies each line into the storage of the line it replaces, so that once the
+// buffer is full, holding the most recent lines of an input allocates memory
+// only for a line longer than any line previously held in the same slot. The
+// storage for lines is carved from blocks of memory, rather than allocated for
+// each line.
+type lineRing struct {
+	ring[heldLine]
+	arena []byte // storage not yet carved for any line
+}
+
+// newLineRing returns a circular buffer for n lines, where n is greater than 0.
+func newLineRing(n int) *lineRing {
+	return &lineRing{ring: *newRing[heldLine](n)}
+}
+
+// push stores a copy of line in the buffer, replacing the oldest line when the
+// buffer is full, so that line may be overwritten once push returns. Any line
+// returned by oldest is invalid after push.
+func (lr *lineRing) push(lineNumber int, line []byte) {
+	slot := lr.next()
+	slot.lineNumber = lineNumber
+	slot.text = lr.store(slot.text, line)
+}
+
+// store returns a copy of line, in buf when it has room for line, or otherwise in
+// storage carved from the arena, with room to spare for a longer line.
+func (lr *lineRing) store(buf, line []byte) []byte {
+	if len(line) <= cap(buf) {
+		return append(buf[:0], line...)
+	}
+
+	size := 16
+	for size < len(line) {
+		size <<= 1
+	}
+	if size > arenaSize/4 {
+		// Long lines would waste too much of the arena.
+		return append(make([]byte, 0, size), line...)
+	}
+	if size > len(lr.arena) {
+		lr.arena = make([]byte, arenaSize)
+	}
+
+	buf, lr.arena = lr.arena[:0:size], lr.arena[size:]
+	return append(buf, line...)
+}
+
+// held returns the line number of the oldest line in the buffer, or false when
+// the buffer is empty.
+func (lr *lineRing) held() (int, bool) {
+	switch {
+	case lr.looped:
+		return lr.items[lr.index].lineNumber, true
+	case lr.index > 0:
+		return lr.items[0].lineNumber, true
+	}
+	return 0, false
+}
+
+// drain invokes emit for each line in the buffer, from the oldest line to the
+// newest line.
+func (lr *lineRing) drain(emit emitFunc) error {
+	return lr.each(func(held *heldLine) error {
+		return emit(held.lineNumber, held.text)
+	})
+}
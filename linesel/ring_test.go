@@ -0,0 +1,161 @@
+package linesel
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// ringItems returns the items in r, from the oldest to the newest.
+func ringItems(r *ring[int]) []int {
+	var items []int
+	_ = r.each(func(item *int) error {
+		items = append(items, *item)
+		return nil
+	})
+	return items
+}
+
+func TestRing(t *testing.T) {
+	cases := []struct {
+		n, stored int
+		want      []int
+		full      bool
+	}{
+		{1, 0, nil, false},
+		{1, 1, []int{1}, true},
+		{1, 3, []int{3}, true},
+		{3, 2, []int{1, 2}, false},
+		{3, 3, []int{1, 2, 3}, true},
+		{3, 4, []int{2, 3, 4}, true},
+		{3, 8, []int{6, 7, 8}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%d of %d", tc.stored, tc.n), func(t *testing.T) {
+			r := newRing[int](tc.n)
+			for i := 1; i <= tc.stored; i++ {
+				*r.next() = i
+			}
+			if got := ringItems(r); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("GOT: %v; WANT: %v", got, tc.want)
+			}
+			if got := r.full(); got != tc.full {
+				t.Errorf("full: GOT: %v; WANT: %v", got, tc.full)
+			}
+			if tc.full {
+				if got := *r.oldest(); got != tc.want[0] {
+					t.Errorf("oldest: GOT: %v; WANT: %v", got, tc.want[0])
+				}
+			}
+		})
+	}
+}
+
+func TestRingEachStopsAtError(t *testing.T) {
+	r := newRing[int](3)
+	for i := 1; i <= 5; i++ {
+		*r.next() = i
+	}
+	var visited []int
+	err := r.each(func(item *int) error {
+		visited = append(visited, *item)
+		if *item == 4 {
+			return ErrClosed
+		}
+		return nil
+	})
+	if err != ErrClosed || fmt.Sprint(visited) != "[3 4]" {
+		t.Errorf("GOT: %v, %v; WANT: %v, %v", visited, err, "[3 4]", ErrClosed)
+	}
+}
+
+func TestLineRing(t *testing.T) {
+	long := strings.Repeat("x", arenaSize/4+1)
+	lines := []string{"", "a", "short line", long, "b", strings.Repeat("y", 100), "", long + "z", "c"}
+
+	for n := 1; n <= len(lines)+1; n++ {
+		t.Run(fmt.Sprintf("%d lines", n), func(t *testing.T) {
+			lr := newLineRing(n)
+			if _, ok := lr.held(); ok {
+				t.Errorf("held: GOT: %v; WANT: %v", ok, false)
+			}
+
+			// Every line is written from the same buffer, which is overwritten
+			// after each push, so any line that aliases it is detected.
+			var buf []byte
+			for i, line := range lines {
+				buf = append(buf[:0], line...)
+				lr.push(i+1, buf)
+				for j := range buf {
+					buf[j] = '!'
+				}
+
+				first := 1
+				if i+1 > n {
+					first = i + 2 - n
+				}
+				if got, ok := lr.held(); got != first || !ok {
+					t.Errorf("held: GOT: %d, %v; WANT: %d, %v", got, ok, first, true)
+				}
+			}
+
+			first := 0
+			if len(lines) > n {
+				first = len(lines) - n
+			}
+			var got []string
+			err := lr.drain(func(lineNumber int, line []byte) error {
+				if want := first + len(got) + 1; lineNumber != want {
+					t.Errorf("line number: GOT: %d; WANT: %d", lineNumber, want)
+				}
+				got = append(got, string(line))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			want := lines[first:]
+			if len(got) != len(want) {
+				t.Fatalf("GOT: %d lines; WANT: %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("line %d: GOT: %d bytes %.10q; WANT: %d bytes %.10q", first+i+1, len(got[i]), got[i], len(want[i]), want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLineRingDoesNotAllocateOnceWarm(t *testing.T) {
+	lr := newLineRing(8)
+	line := []byte("a line no longer than the lines before it")
+	for i := 0; i < 8; i++ {
+		lr.push(i, line)
+	}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		lr.push(0, line[:len(line)/2])
+		lr.push(0, line)
+	})
+	if allocs != 0 {
+		t.Errorf("GOT: %v allocations; WANT: %v", allocs, 0)
+	}
+}
+
+func TestLineRingCarvesArena(t *testing.T) {
+	lr := newLineRing(4)
+	for i := 0; i < 4; i++ {
+		lr.push(i, []byte("line"))
+	}
+
+	// Each short line is carved from the arena with a capacity limited to its
+	// own storage, so that appending to one cannot overwrite another.
+	_ = lr.each(func(held *heldLine) error {
+		if got, want := cap(held.text), 16; got != want {
+			t.Errorf("GOT: capacity %d; WANT: %d", got, want)
+		}
+		return nil
+	})
+}
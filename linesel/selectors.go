@@ -0,0 +1,390 @@
+package linesel
+
+// Top is a Selector that selects the initial N lines of its input, similar to
+// head.
+type Top int
+
+func (t Top) start(emit emitFunc) (selection, error) {
+	switch {
+	case t < 0:
+		return nil, ErrNegative
+	case t == 0:
+		return nil, nil
+	}
+	return &topSelection{emit: emit, remaining: int(t)}, nil
+}
+
+type topSelection struct {
+	emit      emitFunc
+	remaining int
+}
+
+func (ts *topSelection) line(_, lineNumber int, line []byte) (bool, error) {
+	if err := ts.emit(lineNumber, line); err != nil {
+		return false, err
+	}
+	ts.remaining--
+	return ts.remaining > 0, nil
+}
+
+func (ts *topSelection) end() error { return nil }
+
+// Bottom is a Selector that selects the final N lines of its input, similar to
+// tail. Only the final N lines are held in memory.
+type Bottom int
+
+func (b Bottom) start(emit emitFunc) (selection, error) {
+	switch {
+	case b < 0:
+		return nil, ErrNegative
+	case b == 0:
+		return nil, nil
+	}
+	return bottomSelection{emit: emit, lines: newLineRing(int(b))}, nil
+}
+
+type bottomSelection struct {
+	emit  emitFunc
+	lines *lineRing
+}
+
+func (bs bottomSelection) line(_, lineNumber int, line []byte) (bool, error) {
+	bs.lines.push(lineNumber, line)
+	return true, nil
+}
+
+func (bs bottomSelection) end() error {
+	return bs.lines.drain(bs.emit)
+}
+
+func (bs bottomSelection) held() (int, bool) { return bs.lines.held() }
+
+// Skip is a Selector that selects every line of its input except the Initial
+// lines and the Final lines, such as to omit a header and a footer. Only the
+// Final lines are held in memory. The zero value selects every line.
+type Skip struct {
+	Initial, Final int
+}
+
+func (s Skip) start(emit emitFunc) (selection, error) {
+	if s.Initial < 0 || s.Final < 0 {
+		return nil, ErrNegative
+	}
+	ss := &skipSelection{emit: emit, initial: s.Initial}
+	if s.Final > 0 {
+		ss.final = newLineRing(s.Final)
+	}
+	return ss, nil
+}
+
+type skipSelection struct {
+	emit    emitFunc
+	initial int       // lines yet to be skipped
+	final   *lineRing // when not nil, holds the lines that may be final
+}
+
+func (ss *skipSelection) line(_, lineNumber int, line []byte) (bool, error) {
+	if ss.initial > 0 {
+		ss.initial--
+		return true, nil
+	}
+
+	if ss.final == nil {
+		return true, ss.emit(lineNumber, line)
+	}
+
+	// Once the circular buffer is full, the oldest line it holds is followed by
+	// at least N lines, so it cannot be one of the final N lines.
+	if ss.final.full() {
+		held := ss.final.oldest()
+		if err := ss.emit(held.lineNumber, held.text); err != nil {
+			return false, err
+		}
+	}
+	ss.final.push(lineNumber, line)
+	return true, nil
+}
+
+func (ss *skipSelection) end() error { return nil }
+
+func (ss *skipSelection) held() (int, bool) {
+	if ss.final == nil {
+		return 0, false
+	}
+	return ss.final.held()
+}
+
+// Invert returns a Selector that selects only the lines of its input that sel
+// would not select. Inverting Top(N) is equivalent to Skip{Initial: N}, and
+// inverting Bottom(N) is equivalent to Skip{Final: N}, while inverting Skip
+// selects only its Initial and Final lines. Inverting a Selection of more than
+// one stage selects the lines that do not survive every stage, which requires
+// holding each line until it is known whether it does, so the lines following
+// the oldest line held by any stage are also held in memory.
+func Invert(sel Selector) Selector {
+	switch s := sel.(type) {
+	case Top:
+		return Skip{Initial: int(s)}
+	case Bottom:
+		return Skip{Final: int(s)}
+	case Skip:
+		if s.Initial < 0 || s.Final < 0 {
+			return invalid{ErrNegative}
+		}
+		var rs Ranges
+		if s.Initial != 0 {
+			rs.intervals = append(rs.intervals, interval{start: 1, end: s.Initial})
+		}
+		if s.Final != 0 {
+			rs.intervals = append(rs.intervals, interval{start: -s.Final})
+		}
+		return rs
+	case Ranges:
+		return s.Invert()
+	case Selection:
+		switch len(s) {
+		case 0:
+			return Ranges{}
+		case 1:
+			return Invert(s[0])
+		}
+		return complement{s}
+	case complement:
+		return s.sel
+	}
+	return sel // an invalid Selector remains invalid
+}
+
+// complement is a Selector that selects only the lines of its input that sel
+// does not select.
+type complement struct {
+	sel Selection
+}
+
+func (c complement) start(emit emitFunc) (selection, error) {
+	cs := &complementSelection{emit: emit}
+	st, err := c.sel.start(cs.selected)
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		// Every line is outside a selection of no lines.
+		return Skip{}.start(emit)
+	}
+	cs.inner = st
+	return cs, nil
+}
+
+// complementSelection presents each line of its input to inner, numbered by
+// its position in the input, and holds a copy of the line until inner either
+// selects it, or can no longer select it, in which case the line is emitted.
+// Because inner selects lines in order, once it selects a line, or holds no
+// line older than a line, none of the lines preceding that line will be
+// selected.
+type complementSelection struct {
+	emit  emitFunc
+	inner selection  // nil once no further lines will be selected by inner
+	lines []heldLine // lines[head:] are held, oldest first
+	head  int
+	first int // position in the input of lines[head]
+}
+
+func (cs *complementSelection) line(n, lineNumber int, line []byte) (bool, error) {
+	if cs.inner == nil {
+		return true, cs.emit(lineNumber, line)
+	}
+
+	cs.hold(n, lineNumber, line)
+
+	more, err := cs.inner.line(n, n, line)
+	if err != nil {
+		return false, err
+	}
+	if !more {
+		err = cs.inner.end()
+		cs.inner = nil
+		if err != nil {
+			return false, err
+		}
+		return true, cs.release(maxInt)
+	}
+
+	next := n + 1
+	if h, ok := cs.inner.(holder); ok {
+		if oldest, ok := h.held(); ok {
+			next = oldest
+		}
+	}
+	return true, cs.release(next)
+}
+
+func (cs *complementSelection) end() error {
+	if cs.inner != nil {
+		err := cs.inner.end()
+		cs.inner = nil
+		if err != nil {
+			return err
+		}
+	}
+	return cs.release(maxInt)
+}
+
+func (cs *complementSelection) held() (int, bool) {
+	if cs.head == len(cs.lines) {
+		return 0, false
+	}
+	return cs.lines[cs.head].lineNumber, true
+}
+
+// selected is invoked by inner with the position in the input of each line it
+// selects, which must not be emitted.
+func (cs *complementSelection) selected(n int, _ []byte) error {
+	if err := cs.release(n); err != nil {
+		return err
+	}
+	if cs.head < len(cs.lines) {
+		cs.head++
+		cs.first++
+	}
+	return nil
+}
+
+// hold stores a copy of the line at position n of the input, reusing the
+// storage of lines previously released.
+func (cs *complementSelection) hold(n, lineNumber int, line []byte) {
+	if cs.head == len(cs.lines) {
+		cs.lines, cs.head, cs.first = cs.lines[:0], 0, n
+	} else if cs.head > len(cs.lines)/2 {
+		// Swap rather than copy, so that no two lines share storage.
+		held := len(cs.lines) - cs.head
+		for i := 0; i < held; i++ {
+			cs.lines[i], cs.lines[cs.head+i] = cs.lines[cs.head+i], cs.lines[i]
+		}
+		cs.lines, cs.head = cs.lines[:held], 0
+	}
+
+	if len(cs.lines) < cap(cs.lines) {
+		cs.lines = cs.lines[:len(cs.lines)+1]
+	} else {
+		cs.lines = append(cs.lines, heldLine{})
+	}
+	slot := &cs.lines[len(cs.lines)-1]
+	slot.lineNumber = lineNumber
+	slot.text = append(slot.text[:0], line...)
+}
+
+// release emits each held line preceding position n of the input.
+func (cs *complementSelection) release(n int) error {
+	for cs.head < len(cs.lines) && cs.first < n {
+		held := &cs.lines[cs.head]
+		cs.head++
+		cs.first++
+		if err := cs.emit(held.lineNumber, held.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalid is a Selector that fails to select lines with err.
+type invalid struct {
+	err error
+}
+
+func (iv invalid) start(emitFunc) (selection, error) { return nil, iv.err }
+
+// Selection is a Selector that selects lines in stages, like the pipeline of
+// the lines command: each of its Selectors selects from the lines selected by
+// the Selector preceding it, and the lines selected by its final Selector are
+// the lines the Selection selects, numbered as they were in the original input.
+// An empty Selection selects every line.
+//
+//	// Select the final 5 lines that follow a one line header.
+//	sel := linesel.Selection{linesel.Skip{Initial: 1}, linesel.Bottom(5)}
+type Selection []Selector
+
+func (s Selection) start(emit emitFunc) (selection, error) {
+	if len(s) == 0 {
+		return Skip{}.start(emit)
+	}
+
+	ss := &stagedSelection{stages: make([]*stage, len(s))}
+
+	// Start with the final stage, so that each stage emits the lines it
+	// selects to the following stage.
+	for i := len(s) - 1; i >= 0; i-- {
+		st, err := s[i].start(emit)
+		if err != nil || st == nil {
+			// A stage that selects no lines leaves none for the following
+			// stages to select.
+			return nil, err
+		}
+		ss.stages[i] = &stage{selection: st}
+		emit = ss.stages[i].present
+	}
+
+	return ss, nil
+}
+
+// stage is a single stage of a stagedSelection, which counts the lines
+// presented to it by the preceding stage.
+type stage struct {
+	selection
+	n    int  // number of lines presented thus far
+	done bool // true after line returned false
+}
+
+// present presents the next line selected by the preceding stage to the stage.
+func (st *stage) present(lineNumber int, line []byte) error {
+	if st.done {
+		return nil
+	}
+	st.n++
+	more, err := st.line(st.n, lineNumber, line)
+	st.done = !more
+	return err
+}
+
+// stagedSelection presents each line of its input to the first of its stages,
+// each of which emits the lines it selects to the following stage.
+type stagedSelection struct {
+	stages []*stage
+}
+
+func (ss *stagedSelection) line(_, lineNumber int, line []byte) (bool, error) {
+	if err := ss.stages[0].present(lineNumber, line); err != nil {
+		return false, err
+	}
+	// Once any stage will select no further lines, no further lines will
+	// reach the stages after it.
+	for _, st := range ss.stages {
+		if st.done {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (ss *stagedSelection) held() (int, bool) {
+	var oldest int
+	var found bool
+	for _, st := range ss.stages {
+		if h, ok := st.selection.(holder); ok {
+			if n, ok := h.held(); ok && (!found || n < oldest) {
+				oldest, found = n, true
+			}
+		}
+	}
+	return oldest, found
+}
+
+func (ss *stagedSelection) end() error {
+	// Each stage may emit the lines it holds to the following stage as it
+	// ends, so they must end in order.
+	for _, st := range ss.stages {
+		if err := st.end(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package linesel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns n lines, each consisting of its own line number.
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		b.WriteString(strconv.Itoa(i))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// copyString returns the lines sel selects from input, joined by commas.
+func copyString(t *testing.T, sel Selector, input string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Copy(&out, strings.NewReader(input), sel); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	return strings.Join(strings.Fields(out.String()), ",")
+}
+
+func TestSelectors(t *testing.T) {
+	cases := []struct {
+		name string
+		sel  Selector
+		n    int
+		want string
+	}{
+		{"top", Top(2), 6, "1,2"},
+		{"top zero", Top(0), 6, ""},
+		{"top more than input", Top(8), 3, "1,2,3"},
+		{"bottom", Bottom(2), 6, "5,6"},
+		{"bottom zero", Bottom(0), 6, ""},
+		{"bottom more than input", Bottom(8), 3, "1,2,3"},
+		{"bottom no input", Bottom(2), 0, ""},
+		{"skip initial", Skip{Initial: 2}, 6, "3,4,5,6"},
+		{"skip final", Skip{Final: 2}, 6, "1,2,3,4"},
+		{"skip both", Skip{Initial: 1, Final: 2}, 6, "2,3,4"},
+		{"skip nothing", Skip{}, 3, "1,2,3"},
+		{"skip all", Skip{Initial: 2, Final: 2}, 4, ""},
+		{"skip more than input", Skip{Initial: 2, Final: 2}, 3, ""},
+		{"selection", Selection{Skip{Initial: 1}, Bottom(3), Top(2)}, 6, "4,5"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := copyString(t, tc.sel, numberedLines(tc.n)); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSelectorsHoldOnlyFinalLines(t *testing.T) {
+	cases := []struct {
+		sel  Selector
+		n    int
+		want int // oldest line held, or 0 when none
+	}{
+		{Bottom(3), 2, 1},
+		{Bottom(3), 10, 8},
+		{Skip{Initial: 4}, 10, 0},
+		{Skip{Initial: 4, Final: 3}, 5, 5},
+		{Skip{Initial: 4, Final: 3}, 10, 8},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%#v", tc.sel), func(t *testing.T) {
+			state, err := tc.sel.start(func(int, []byte) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i <= tc.n; i++ {
+				if _, err := state.line(i, i, []byte("line")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, ok := state.(holder).held()
+			if got != tc.want || ok != (tc.want > 0) {
+				t.Errorf("GOT: %d, %v; WANT: %d, %v", got, ok, tc.want, tc.want > 0)
+			}
+		})
+	}
+}
+
+func TestInvertSelection(t *testing.T) {
+	cases := []struct {
+		name string
+		sel  Selection
+		n    int
+		want string
+	}{
+		{"empty", Selection{}, 6, ""},
+		{"single stage", Selection{Top(2)}, 6, "3,4,5,6"},
+		{"skip then top", Selection{Skip{Initial: 1}, Top(3)}, 6, "1,5,6"},
+		{"skip then bottom", Selection{Skip{Initial: 1}, Bottom(2)}, 6, "1,2,3,4"},
+		{"bottom then skip", Selection{Bottom(4), Skip{Initial: 1, Final: 1}}, 6, "1,2,3,6"},
+		{"top then top", Selection{Top(4), Top(2)}, 6, "3,4,5,6"},
+		{"selects nothing", Selection{Top(2), Skip{Initial: 2}}, 4, "1,2,3,4"},
+		{"short input", Selection{Skip{Initial: 1}, Top(3)}, 2, "1"},
+		{"no input", Selection{Skip{Initial: 1}, Top(3)}, 0, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := copyString(t, Invert(tc.sel), numberedLines(tc.n)); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInvertSelectionComplements(t *testing.T) {
+	tail, err := ParseRanges("-3:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := ParseRanges("/3/,+1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selections := []Selection{
+		{Skip{Initial: 1}, Top(3)},
+		{Skip{Final: 2}, Bottom(3)},
+		{Bottom(5), Skip{Initial: 1}, Top(2)},
+		{tail, Top(1)},
+		{pattern, Skip{Final: 1}},
+		{Skip{Initial: 2}, Invert(Selection{Top(3), Skip{Initial: 1}})},
+		{Invert(tail), Bottom(2)},
+	}
+
+	for _, sel := range selections {
+		for n := 0; n <= 14; n++ {
+			input := numberedLines(n)
+			selected := make(map[string]bool)
+			for _, line := range strings.Split(copyString(t, sel, input), ",") {
+				selected[line] = true
+			}
+			var want []string
+			for i := 1; i <= n; i++ {
+				if line := strconv.Itoa(i); !selected[line] {
+					want = append(want, line)
+				}
+			}
+			if got := copyString(t, Invert(sel), input); got != strings.Join(want, ",") {
+				t.Errorf("%v of %d lines: GOT: %q; WANT: %q", sel, n, got, strings.Join(want, ","))
+			}
+		}
+	}
+}
+
+func TestInvertInvertedSelection(t *testing.T) {
+	sel := Selection{Skip{Initial: 1}, Top(3)}
+	if got, want := copyString(t, Invert(Invert(sel)), numberedLines(6)), "2,3,4"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+}
+
+func TestInvert(t *testing.T) {
+	ranges := func(expr string) Ranges {
+		rs, err := ParseRanges(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+
+	cases := []struct {
+		name string
+		sel  Selector
+		want string
+	}{
+		{"top", Top(2), "3,4,5,6"},
+		{"bottom", Bottom(2), "1,2,3,4"},
+		{"skip", Skip{Initial: 1, Final: 2}, "1,5,6"},
+		{"skip initial", Skip{Initial: 2}, "1,2"},
+		{"skip final", Skip{Final: 2}, "5,6"},
+		{"skip nothing", Skip{}, ""},
+		{"ranges", ranges("3-5"), "1,2,6"},
+		{"end-relative ranges", ranges("-2:"), "1,2,3,4"},
+		{"pattern ranges", ranges("/3/,/5/"), "1,2,6"},
+		{"inverted ranges", ranges("3-5").Invert(), "3,4,5"},
+		{"zero ranges", Ranges{}, "1,2,3,4,5,6"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := copyString(t, Invert(tc.sel), numberedLines(6)); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInvertNegative(t *testing.T) {
+	for _, sel := range []Selector{Top(-1), Bottom(-1), Skip{Initial: -1}, Skip{Final: -1}} {
+		var out bytes.Buffer
+		if err := Copy(&out, strings.NewReader("1\n"), Invert(sel)); !errors.Is(err, ErrNegative) {
+			t.Errorf("%#v: GOT: %v; WANT: %v", sel, err, ErrNegative)
+		}
+	}
+}
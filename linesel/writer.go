@@ -0,0 +1,131 @@
+package linesel
+
+import (
+	"bytes"
+	"io"
+)
+
+// NewWriter returns an io.WriteCloser that writes to w only the lines sel
+// selects from the bytes written to it, each followed by a newline. It is the
+// counterpart of NewReader, for when the lines are pushed by a producer rather
+// than pulled by a consumer, such as the output of a command:
+//
+//	// Omit the initial 2 lines and the final line of the output of cmd.
+//	out := linesel.NewWriter(os.Stdout, linesel.Skip{Initial: 2, Final: 1})
+//	cmd.Stdout = out
+//	err := cmd.Run()
+//	if err2 := out.Close(); err == nil {
+//		err = err2
+//	}
+//
+// Bytes may be written in chunks of any size, and a line may be split across
+// any number of calls to Write. Only the bytes of the line being written, and
+// the lines sel must hold, such as the final lines omitted by Skip, are held in
+// memory. A line whose selection depends on the lines that follow it is written
+// to w once enough lines have followed it, or when none will, by Close, which
+// also presents a final line that does not end with a newline. Close does not
+// close w. Once sel will select no further lines, the bytes written are
+// discarded without error, so the producer is never blocked.
+func NewWriter(w io.Writer, sel Selector) io.WriteCloser {
+	sw := &writer{w: w}
+	sw.state, sw.err = sel.start(sw.emit)
+	return sw
+}
+
+// writer is an io.WriteCloser that writes to w the lines a selection selects
+// from the lines written to it.
+type writer struct {
+	w       io.Writer
+	state   selection // nil when no further lines will be selected
+	partial []byte    // bytes of a line not yet ended by a newline
+	n       int       // number of lines presented thus far
+	buf     []byte    // reused to write each selected line with its newline
+	closed  bool
+	err     error // when not nil, returned by Write and Close
+}
+
+func (sw *writer) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrClosed
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+
+	written := len(p)
+
+	for sw.state != nil {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			sw.partial = append(sw.partial, p...)
+			break
+		}
+
+		line := p[:i]
+		if len(sw.partial) > 0 {
+			// Line began in a previous call to Write.
+			sw.partial = append(sw.partial, line...)
+			line = sw.partial
+		}
+		if err := sw.line(line); err != nil {
+			return written - len(p), err
+		}
+		sw.partial = sw.partial[:0]
+		p = p[i+1:]
+	}
+
+	return written, nil
+}
+
+// Close presents the final line to the selection when it does not end with a
+// newline, then writes to w the remaining lines the selection selects.
+func (sw *writer) Close() error {
+	if sw.closed {
+		return sw.err
+	}
+	sw.closed = true
+
+	if sw.err == nil && sw.state != nil && len(sw.partial) > 0 {
+		if sw.err = sw.line(sw.partial); sw.err != nil {
+			return sw.err
+		}
+	}
+	sw.partial = nil
+
+	if sw.err == nil && sw.state != nil {
+		sw.end()
+	}
+
+	return sw.err
+}
+
+// line presents a single line, without its newline, to the selection, ending
+// the selection once it will select no further lines.
+func (sw *writer) line(line []byte) error {
+	if l := len(line); l > 0 && line[l-1] == '\r' {
+		line = line[:l-1]
+	}
+
+	sw.n++
+	more, err := sw.state.line(sw.n, sw.n, line)
+	if err != nil {
+		sw.err = err
+		return err
+	}
+	if !more {
+		sw.end()
+	}
+	return sw.err
+}
+
+// end ends the selection, which may write the lines it holds.
+func (sw *writer) end() {
+	sw.err = sw.state.end()
+	sw.state = nil
+}
+
+// emit writes a selected line to w.
+func (sw *writer) emit(lineNumber int, line []byte) (err error) {
+	sw.buf, err = writeLine(sw.w, lineNumber, line, sw.buf)
+	return err
+}
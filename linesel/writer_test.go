@@ -0,0 +1,250 @@
+package linesel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// writeChunks writes input to a writer for sel in chunks of size bytes, then
+// closes it, and returns the bytes written to the underlying writer.
+func writeChunks(t *testing.T, sel Selector, input string, size int) string {
+	t.Helper()
+	var out bytes.Buffer
+	w := NewWriter(&out, sel)
+	for p := []byte(input); len(p) > 0; {
+		chunk := p
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		n, err := w.Write(chunk)
+		if err != nil {
+			t.Fatalf("GOT: %v; WANT: %v", err, nil)
+		}
+		if n != len(chunk) {
+			t.Fatalf("GOT: %d; WANT: %d", n, len(chunk))
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	return out.String()
+}
+
+func TestWriterMatchesCopy(t *testing.T) {
+	ranges, err := ParseRanges("2,4:6,-2:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selectors := []struct {
+		name string
+		sel  Selector
+	}{
+		{"top", Top(3)},
+		{"bottom", Bottom(3)},
+		{"skip", Skip{Initial: 2, Final: 2}},
+		{"ranges", ranges},
+		{"invert", Invert(Top(2))},
+		{"selection", Selection{Skip{Initial: 1}, Bottom(4), Top(2)}},
+	}
+
+	inputs := []string{
+		"",
+		"1\n",
+		numberedLines(10),
+		"1\n2\n3\n4\n5\n6\n7\n8\n9\n10",
+		"one\r\ntwo\r\nthree\r\nfour\r\nfive\r\nsix\r\nseven\r\n",
+		"\n\nlong line of several words\n\n\n\n\n",
+	}
+
+	for _, s := range selectors {
+		t.Run(s.name, func(t *testing.T) {
+			for _, input := range inputs {
+				var want bytes.Buffer
+				if err := Copy(&want, strings.NewReader(input), s.sel); err != nil {
+					t.Fatal(err)
+				}
+				for size := 1; size <= len(input); size++ {
+					if got := writeChunks(t, s.sel, input, size); got != want.String() {
+						t.Errorf("%q in chunks of %d: GOT: %q; WANT: %q", input, size, got, want.String())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriterSplitLines(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole lines", []string{"a\n", "b\n"}, "a\nb\n"},
+		{"line across writes", []string{"a", "bc", "d\ne", "f\n"}, "abcd\nef\n"},
+		{"several lines in a write", []string{"a\nb\nc", "\n"}, "a\nb\nc\n"},
+		{"carriage return across writes", []string{"a\r", "\nb\r\n"}, "a\nb\n"},
+		{"final line without newline", []string{"a\n", "b"}, "a\nb\n"},
+		{"empty writes", []string{"", "a", "", "\n", ""}, "a\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewWriter(&out, Top(10))
+			for _, chunk := range tc.chunks {
+				if _, err := w.Write([]byte(chunk)); err != nil {
+					t.Fatalf("GOT: %v; WANT: %v", err, nil)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if got := out.String(); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriterCloseWritesHeldLines(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, Bottom(2))
+	if _, err := w.Write([]byte(numberedLines(5))); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "" {
+		t.Errorf("before Close: GOT: %q; WANT: %q", got, "")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if got, want := out.String(), "4\n5\n"; got != want {
+		t.Errorf("after Close: GOT: %q; WANT: %q", got, want)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close: GOT: %v; WANT: %v", err, nil)
+	}
+}
+
+func TestWriterWriteAfterClose(t *testing.T) {
+	w := NewWriter(new(bytes.Buffer), Top(1))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Write([]byte("a\n")); n != 0 || !errors.Is(err, ErrClosed) {
+		t.Errorf("GOT: %d, %v; WANT: %d, %v", n, err, 0, ErrClosed)
+	}
+}
+
+func TestWriterDiscardsAfterDone(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, Top(2))
+	for i := 0; i < 3; i++ {
+		input := numberedLines(5) + "partial"
+		if n, err := w.Write([]byte(input)); n != len(input) || err != nil {
+			t.Fatalf("GOT: %d, %v; WANT: %d, %v", n, err, len(input), nil)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	if got, want := out.String(), "1\n2\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+	if sw := w.(*writer); len(sw.partial) != 0 {
+		t.Errorf("GOT: %d bytes held; WANT: %d", len(sw.partial), 0)
+	}
+}
+
+func TestWriterInvalidSelector(t *testing.T) {
+	_, err := ParseRanges("3:1")
+	if err == nil {
+		t.Fatal("GOT: nil; WANT: error")
+	}
+	w := NewWriter(new(bytes.Buffer), invalid{err})
+	if _, err2 := w.Write([]byte("a\n")); err2 != err {
+		t.Errorf("Write: GOT: %v; WANT: %v", err2, err)
+	}
+	if err2 := w.Close(); err2 != err {
+		t.Errorf("Close: GOT: %v; WANT: %v", err2, err)
+	}
+}
+
+// failingWriter returns err from every call to Write.
+type failingWriter struct{ err error }
+
+func (fw failingWriter) Write([]byte) (int, error) { return 0, fw.err }
+
+func TestWriterReturnsWriteError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	w := NewWriter(failingWriter{errWrite}, Top(5))
+	if _, err := w.Write([]byte("a\nb\n")); err != errWrite {
+		t.Errorf("Write: GOT: %v; WANT: %v", err, errWrite)
+	}
+	if err := w.Close(); err != errWrite {
+		t.Errorf("Close: GOT: %v; WANT: %v", err, errWrite)
+	}
+}
+
+// writeRecorder is an io.Writer that records the bytes given to each call to
+// Write.
+type writeRecorder struct {
+	writes []string
+}
+
+func (wr *writeRecorder) Write(p []byte) (int, error) {
+	wr.writes = append(wr.writes, string(p))
+	return len(p), nil
+}
+
+func TestLinesWrittenWithTheirNewlines(t *testing.T) {
+	// Each line is written along with its newline by a single call to Write,
+	// so that writes to the same io.Writer cannot separate them.
+	want := []string{"2\n", "3\n", "4\n"}
+
+	t.Run("Copy", func(t *testing.T) {
+		var wr writeRecorder
+		if err := Copy(&wr, strings.NewReader(numberedLines(5)), Skip{Initial: 1, Final: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(wr.writes, ","); got != strings.Join(want, ",") {
+			t.Errorf("GOT: %q; WANT: %q", wr.writes, want)
+		}
+	})
+
+	t.Run("NewWriter", func(t *testing.T) {
+		var wr writeRecorder
+		w := NewWriter(&wr, Skip{Initial: 1, Final: 1})
+		if _, err := w.Write([]byte(numberedLines(5))); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(wr.writes, ","); got != strings.Join(want, ",") {
+			t.Errorf("GOT: %q; WANT: %q", wr.writes, want)
+		}
+	})
+}
+
+func TestWriterReusesLineBuffer(t *testing.T) {
+	w := NewWriter(io.Discard, Skip{})
+	line := []byte("a line of moderate length\n")
+	if _, err := w.Write(line); err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := w.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("GOT: %v allocations; WANT: %v", allocs, 0)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// Policies for lines longer than --max-line-length.
+const (
+	longLineError    = "error"    // stop with an error
+	longLineTruncate = "truncate" // discard the bytes beyond the maximum
+	longLineSplit    = "split"    // treat each run of maximum bytes as a line
+)
+
+// limitLine applies the --long-lines policy to line when it is longer than
+// --max-line-length bytes, returning the line to use, along with the rest of
+// the line to be used as the following line when the policy is split. The
+// lineNumber is only used to describe the line in an error.
+func limitLine(line []byte, lineNumber int) ([]byte, []byte, error) {
+	max := int(*optMaxLineLength)
+	if max == 0 || len(line) <= max {
+		return line, nil, nil
+	}
+
+	switch *optLongLines {
+	case longLineTruncate:
+		return line[:max], nil, nil
+	case longLineSplit:
+		return line[:max], line[max:], nil
+	default:
+		return nil, nil, fmt.Errorf("line %d is longer than %d bytes; use --long-lines to truncate or split long lines", lineNumber, max)
+	}
+}
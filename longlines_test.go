@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/karrick/lines/linesel"
+)
+
+func TestLimitLine(t *testing.T) {
+	cases := []struct {
+		policy     string
+		line       string
+		want, rest string
+		err        bool
+	}{
+		{longLineError, "1234", "1234", "", false},
+		{longLineError, "12345", "", "", true},
+		{longLineTruncate, "1234", "1234", "", false},
+		{longLineTruncate, "123456789", "1234", "", false},
+		{longLineSplit, "1234", "1234", "", false},
+		{longLineSplit, "123456789", "1234", "56789", false},
+	}
+
+	setOption(t, optMaxLineLength, 4)
+
+	for _, tc := range cases {
+		t.Run(tc.policy+" "+tc.line, func(t *testing.T) {
+			setOption(t, optLongLines, tc.policy)
+			line, rest, err := limitLine([]byte(tc.line), 7)
+			if tc.err {
+				if want := "line 7 is longer than 4 bytes"; err == nil || !strings.HasPrefix(err.Error(), want) {
+					t.Errorf("GOT: %v; WANT: %s", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if string(line) != tc.want || string(rest) != tc.rest {
+				t.Errorf("GOT: %q, %q; WANT: %q, %q", line, rest, tc.want, tc.rest)
+			}
+		})
+	}
+}
+
+func TestLongLinePolicies(t *testing.T) {
+	// Split, the lines of the input are 1234, 567, ab, cd, efgh, and ij, while
+	// truncated, they are 1234, ab, cd, and efgh.
+	const input = "1234567\nab\ncd\nefghij\n"
+
+	rangeStage := func(expr string) stage {
+		rs, err := linesel.ParseRanges(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return selectStage(rs)
+	}
+	half, err := parseAmount("--bottom", "50%")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name            string
+		stage           stage
+		split, truncate string // each printed line, prefixed by its line number
+	}{
+		{"top", selectStage(linesel.Top(2)), "1:1234,2:567", "1:1234,2:ab"},
+		{"bottom", selectStage(linesel.Bottom(2)), "5:efgh,6:ij", "3:cd,4:efgh"},
+		{"skip bottom", selectStage(linesel.Skip{Final: 2}), "1:1234,2:567,3:ab,4:cd", "1:1234,2:ab"},
+		{"range", rangeStage("2-3"), "2:567,3:ab", "2:ab,3:cd"},
+		{"end-relative range", rangeStage("-3:-2"), "4:cd,5:efgh", "2:ab,3:cd"},
+		{"percentage", countedStage(true, func(r io.Reader, w io.Writer, total int) error {
+			return selectLines(r, w, linesel.Bottom(half.Lines(total)))
+		}), "4:cd,5:efgh,6:ij", "3:cd,4:efgh"},
+		{"pipeline", pipeline(selectStage(linesel.Skip{Initial: 1}), selectStage(linesel.Bottom(2))), "5:efgh,6:ij", "3:cd,4:efgh"},
+	}
+
+	name := filepath.Join(writeFiles(t, map[string]string{"input": input}), "input")
+	setOption(t, optMaxLineLength, 4)
+	setOption(t, optNumWidth, 1)
+	setOption(t, optNumSep, ":")
+
+	for _, tc := range cases {
+		for _, policy := range []string{longLineError, longLineTruncate, longLineSplit} {
+			for _, pipe := range []bool{false, true} {
+				for _, number := range []bool{false, true} {
+					source, format := "file", "plain"
+					if pipe {
+						source = "pipe"
+					}
+					if number {
+						format = "numbered"
+					}
+					t.Run(tc.name+"/"+policy+"/"+source+"/"+format, func(t *testing.T) {
+						setOption(t, optLongLines, policy)
+						setOption(t, optNumber, number)
+
+						args := []string{name}
+						if pipe {
+							args = nil
+							setOption(t, &os.Stdin, pipedInput(t, input))
+						}
+
+						got, _, err := runFilter(t, args, tc.stage)
+
+						if policy == longLineError {
+							if want := "line 1 is longer than 4 bytes"; err == nil || !strings.Contains(err.Error(), want) {
+								t.Errorf("GOT: %v, %q; WANT: %s", err, got, want)
+							}
+							return
+						}
+						if err != nil {
+							t.Fatalf("GOT: %v; WANT: %v", err, nil)
+						}
+
+						want := tc.truncate
+						if policy == longLineSplit {
+							want = tc.split
+						}
+						var lines []string
+						for _, line := range strings.Split(want, ",") {
+							if !number {
+								line = line[strings.IndexByte(line, ':')+1:]
+							}
+							lines = append(lines, line)
+						}
+						if want = strings.Join(lines, "\n") + "\n"; got != want {
+							t.Errorf("GOT: %q; WANT: %q", got, want)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestLongLinePoliciesIgnoreIndex(t *testing.T) {
+	// An index records the offsets of the lines of a file before the long
+	// line policy is applied.
+	var b strings.Builder
+	b.WriteString("1234567\n")
+	for i := 2; i <= 5000; i++ {
+		b.WriteString(strconv.Itoa(i) + "\n")
+	}
+	name := filepath.Join(writeFiles(t, map[string]string{"input": b.String()}), "input")
+
+	fh, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeIndex(fh)
+	if err2 := fh.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := linesel.ParseRanges("4100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setOption(t, optMaxLineLength, 4)
+	setOption(t, optNumber, true)
+	setOption(t, optNumWidth, 1)
+	setOption(t, optNumSep, ":")
+
+	cases := []struct {
+		policy, want string
+	}{
+		{longLineTruncate, "4100:4100\n"},
+		{longLineSplit, "4100:4099\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			setOption(t, optLongLines, tc.policy)
+			got, _, err := runFilter(t, []string{name}, selectStage(rs))
+			if err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// pipedInput returns the read end of a pipe from which input may be read.
+func pipedInput(t *testing.T, input string) *os.File {
+	t.Helper()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pr.Close() })
+	go func() {
+		_, _ = pw.WriteString(input)
+		_ = pw.Close()
+	}()
+	return pr
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/karrick/gobls"
+)
+
+// mapThreshold is the size in bytes of the smallest regular file that is
+// mapped into memory when --mmap is auto. Reading smaller files is faster than
+// mapping them.
+const mapThreshold = 1 << 20
+
+// scanLines returns a lineScanner for the lines of r, which must be closed when
+// no longer needed. When r is a regular file, depending on --mmap, the rest of
+// the file may be mapped into memory and scanned in place, rather than read.
+// When reading r may wait for more input, such as when r is a pipe, the lines
+// buffered by w are written before each read.
+func scanLines(r io.Reader, w io.Writer) *lineScanner {
+	if fh, ok := r.(*os.File); ok && *optMmap != "never" {
+		if ls := mapLines(fh, *optMmap == "always"); ls != nil {
+			return ls
+		}
+	}
+	if mayBlock(r) {
+		return newLineScanner(r, gobls.NewScanner(flushingReader{r: r, w: w}))
+	}
+	return newLineScanner(r, gobls.NewScanner(r))
+}
+
+// mapLines returns a lineScanner for the lines of fh from its current offset,
+// after mapping fh into memory, or nil when fh is not mapped. Unless always is
+// true, fh is only mapped when it is at least mapThreshold bytes.
+func mapLines(fh *os.File, always bool) *lineScanner {
+	fi, err := fh.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 || int64(int(fi.Size())) != fi.Size() {
+		return nil
+	}
+	if !always && fi.Size() < mapThreshold {
+		return nil
+	}
+	offset, err := fh.Seek(0, io.SeekCurrent)
+	if err != nil || offset >= fi.Size() {
+		return nil
+	}
+
+	buf, err := mapFile(fh, int(fi.Size()))
+	if err != nil {
+		verbose("cannot map %q into memory: %s\n", fh.Name(), err)
+		return nil
+	}
+
+	ls := newLineScanner(fh, newBufferScanner(buf[offset:]))
+	ls.release = func() error { return unmapFile(buf) }
+	return ls
+}
+
+// bufferScanner wraps gobls.BufferScanner, which never advances past an
+// initial empty line, by scanning any initial empty lines itself.
+type bufferScanner struct {
+	gobls.Scanner
+	empty int // number of initial empty lines yet to be scanned
+	blank bool
+}
+
+// newBufferScanner returns a gobls.Scanner for the lines in buf.
+func newBufferScanner(buf []byte) gobls.Scanner {
+	var empty int
+	for empty < len(buf) && buf[empty] == '\n' {
+		empty++
+	}
+	return &bufferScanner{Scanner: gobls.NewBufferScanner(buf[empty:]), empty: empty}
+}
+
+func (bs *bufferScanner) Scan() bool {
+	if bs.blank = bs.empty > 0; bs.blank {
+		bs.empty--
+		return true
+	}
+	return bs.Scanner.Scan()
+}
+
+func (bs *bufferScanner) Bytes() []byte {
+	if bs.blank {
+		return nil
+	}
+	return bs.Scanner.Bytes()
+}
+
+func (bs *bufferScanner) Text() string {
+	return string(bs.Bytes())
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile returns an error, because mapping files into memory is not supported
+// on this platform.
+func mapFile(fh *os.File, size int) ([]byte, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+// unmapFile does nothing, because mapFile never maps memory on this platform.
+func unmapFile(buf []byte) error {
+	return nil
+}
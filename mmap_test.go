@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/karrick/gobls"
+	"github.com/karrick/lines/linesel"
+)
+
+// benchmarkScan measures printing every line of a file of lines lines, each
+// of length bytes, scanned by the lineScanner returned by scan.
+func benchmarkScan(b *testing.B, lines, length int, scan func(*os.File) *lineScanner) {
+	name := filepath.Join(b.TempDir(), "input.txt")
+	line := strings.Repeat("x", length-1) + "\n"
+	if err := os.WriteFile(name, []byte(strings.Repeat(line, lines)), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	fh, err := os.Open(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fh.Close()
+
+	out := bufio.NewWriter(io.Discard)
+	b.SetBytes(int64(lines * length))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err = fh.Seek(0, io.SeekStart); err != nil {
+			b.Fatal(err)
+		}
+		ls := scan(fh)
+		if ls == nil {
+			b.Skip("cannot map files into memory on this platform")
+		}
+		if err = linesel.Select(out, ls, linesel.Skip{}); err != nil {
+			b.Fatal(err)
+		}
+		if err = ls.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	scanners := []struct {
+		name string
+		scan func(*os.File) *lineScanner
+	}{
+		{"mapped", func(fh *os.File) *lineScanner { return mapLines(fh, true) }},
+		{"streamed", func(fh *os.File) *lineScanner { return newLineScanner(fh, gobls.NewScanner(fh)) }},
+	}
+
+	for _, length := range []int{16, 1024} {
+		for _, s := range scanners {
+			b.Run(strconv.Itoa(length)+"B/"+s.name, func(b *testing.B) {
+				benchmarkScan(b, (16<<20)/length, length, s.scan)
+			})
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/karrick/gobls"
+)
+
+// numberedReader is an io.Reader whose lines are each prefixed by the line
+// number the line had in the original input, followed by a single space. It
+// connects a stage of a pipeline to the preceding stage, so that original line
+// numbers survive each stage.
+type numberedReader struct {
+	io.Reader
+}
+
+// numberedWriter is an io.Writer that prefixes each line written to it by
+// WriteLine with the line number the line had in the original input, followed
+// by a single space, for consumption by a numberedReader.
+type numberedWriter struct {
+	*bufio.Writer
+}
+
+func (nw numberedWriter) WriteLine(lineNumber int, line []byte) error {
+	writeDigits(nw.Writer, lineNumber)
+	_ = nw.WriteByte(' ')
+	_, _ = nw.Write(line)
+	return nw.WriteByte('\n') // bufio.Writer errors are sticky
+}
+
+// flushWriter writes any buffered data to the underlying io.Writer of w.
+func flushWriter(w io.Writer) error {
+	switch lw := w.(type) {
+	case outputWriter:
+		w = lw.Writer
+	case numberedWriter:
+		w = lw.Writer
+	}
+	if bw, ok := w.(*bufio.Writer); ok {
+		return bw.Flush()
+	}
+	return nil
+}
+
+// flushingReader is an io.Reader that writes the data buffered by w before each
+// read from r, so that lines selected from the input already read are printed,
+// or passed to the following stage, rather than held while a read waits for
+// more input to arrive.
+type flushingReader struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (fr flushingReader) Read(p []byte) (int, error) {
+	if err := flushWriter(fr.w); err != nil {
+		return 0, err
+	}
+	return fr.r.Read(p)
+}
+
+// mayBlock returns true when reading from r may wait for input to arrive, as
+// reading from anything other than a regular file, or a section of one, may.
+func mayBlock(r io.Reader) bool {
+	switch rr := r.(type) {
+	case *io.SectionReader:
+		return false
+	case *os.File:
+		fi, err := rr.Stat()
+		return err != nil || !fi.Mode().IsRegular()
+	}
+	return true
+}
+
+// wantsLineNumbers returns true when w needs the original line number of each
+// line written to it.
+func wantsLineNumbers(w io.Writer) bool {
+	switch lw := w.(type) {
+	case numberedWriter:
+		return true
+	case outputWriter:
+		return lw.number
+	}
+	return false
+}
+
+// outputWriter is an io.Writer that prints each line written to it by
+// WriteLine, prefixed with filename followed by a colon when filename is not
+// empty, then with its original line number, right aligned to width columns and
+// followed by separator, when number is true.
+type outputWriter struct {
+	*bufio.Writer
+	filename  string
+	number    bool
+	width     int
+	separator string
+}
+
+func (ow outputWriter) WriteLine(lineNumber int, line []byte) error {
+	if ow.filename != "" {
+		_, _ = ow.WriteString(ow.filename)
+		_ = ow.WriteByte(':')
+	}
+	if ow.number {
+		for i := digitCount(lineNumber); i < ow.width; i++ {
+			_ = ow.WriteByte(' ')
+		}
+		writeDigits(ow.Writer, lineNumber)
+		_, _ = ow.WriteString(ow.separator)
+	}
+	_, _ = ow.Write(line)
+	return ow.WriteByte('\n') // bufio.Writer errors are sticky
+}
+
+// writeDigits writes the decimal digits of n to bw. The digits are written one
+// byte at a time, because bufio.Writer.Write may pass its argument to the
+// underlying io.Writer, which would allocate the digits on the heap.
+func writeDigits(bw *bufio.Writer, n int) {
+	var buf [20]byte
+	for _, digit := range strconv.AppendInt(buf[:0], int64(n), 10) {
+		_ = bw.WriteByte(digit)
+	}
+}
+
+// digitCount returns the number of decimal digits of n, which must not be
+// negative.
+func digitCount(n int) int {
+	count := 1
+	for ; n >= 10; n /= 10 {
+		count++
+	}
+	return count
+}
+
+// lineScanner scans lines from an io.Reader, tracking both the count of lines
+// scanned thus far, and the line number each line had in the original input.
+// The two only differ when the io.Reader is a numberedReader.
+type lineScanner struct {
+	gobls.Scanner
+	numbered   bool
+	count      int          // number of lines scanned thus far
+	lineNumber int          // original line number of most recently scanned line
+	line       []byte       // most recently scanned line, without any prefix
+	rest       []byte       // remainder of a long line being split
+	err        error        // when not nil, error that stopped scanning
+	release    func() error // when not nil, releases resources used by Scanner
+}
+
+// newLineScanner returns a lineScanner that reads lines from r using s, which
+// must have been created to read from r.
+func newLineScanner(r io.Reader, s gobls.Scanner) *lineScanner {
+	_, numbered := r.(numberedReader)
+	return &lineScanner{Scanner: s, numbered: numbered}
+}
+
+// Scan advances to the next line, returning false when there are no more lines
+// to scan.
+func (ls *lineScanner) Scan() bool {
+	if ls.err != nil {
+		return false
+	}
+
+	var line []byte
+
+	if ls.rest != nil {
+		line, ls.rest = ls.rest, nil
+	} else if ls.Scanner.Scan() {
+		line = ls.Scanner.Bytes()
+	} else {
+		return false
+	}
+
+	ls.count++
+	ls.lineNumber, ls.line = ls.count, line
+
+	if ls.numbered {
+		// Lines from a preceding stage were limited when they were read.
+		if i := bytes.IndexByte(ls.line, ' '); i > 0 {
+			if n, err := strconv.Atoi(string(ls.line[:i])); err == nil {
+				ls.lineNumber, ls.line = n, ls.line[i+1:]
+			}
+		}
+		return true
+	}
+
+	if ls.line, ls.rest, ls.err = limitLine(ls.line, ls.count); ls.err != nil {
+		return false
+	}
+
+	return true
+}
+
+// Err returns the error that stopped scanning, if any.
+func (ls *lineScanner) Err() error {
+	if ls.err != nil {
+		return ls.err
+	}
+	return ls.Scanner.Err()
+}
+
+// LineNumber returns the line number the most recently scanned line had in the
+// original input.
+func (ls *lineScanner) LineNumber() int {
+	return ls.lineNumber
+}
+
+// Bytes returns the most recently scanned line, without any line number
+// prefix. The underlying array may be overwritten by the following call to
+// Scan.
+func (ls *lineScanner) Bytes() []byte {
+	return ls.line
+}
+
+// Text returns the most recently scanned line, without any line number prefix.
+func (ls *lineScanner) Text() string {
+	return string(ls.line)
+}
+
+// Close releases any resources used to scan lines. The lineScanner must not be
+// used after it is closed.
+func (ls *lineScanner) Close() error {
+	if ls.release == nil {
+		return nil
+	}
+	release := ls.release
+	ls.Scanner, ls.line, ls.release = nil, nil, nil
+	return release()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karrick/gobls"
+	"github.com/karrick/lines/linesel"
+)
+
+func TestOutputWriter(t *testing.T) {
+	cases := []struct {
+		name string
+		ow   outputWriter
+		want string
+	}{
+		{"plain", outputWriter{}, "line\n"},
+		{"filename", outputWriter{filename: "a.log"}, "a.log:line\n"},
+		{"number", outputWriter{number: true, width: 6, separator: "\t"}, "    42\tline\n"},
+		{"narrow number", outputWriter{number: true, width: 1, separator: ": "}, "42: line\n"},
+		{"filename and number", outputWriter{filename: "a.log", number: true, width: 3, separator: " "}, "a.log: 42 line\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			tc.ow.Writer = bufio.NewWriter(&b)
+			if err := linesel.WriteLine(tc.ow, 42, []byte("line")); err != nil {
+				t.Fatal(err)
+			}
+			if err := flushWriter(tc.ow); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// readWithin returns the next n bytes read from r, failing the test when they
+// do not arrive within a few seconds.
+func readWithin(t *testing.T, r io.Reader, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(r, buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GOT: %v; WANT: %v", err, nil)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("GOT: no output; WANT: %d bytes", n)
+	}
+	return string(buf)
+}
+
+func TestSelectLinesFlushesBeforeWaiting(t *testing.T) {
+	// Lines selected from the input that has arrived are printed while the
+	// input remains open, rather than when the output buffer fills.
+	input, pw := io.Pipe()
+	output, ow := io.Pipe()
+	defer output.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		bw := bufio.NewWriter(ow)
+		err := selectLines(input, bw, linesel.Skip{Initial: 1})
+		if err == nil {
+			err = bw.Flush()
+		}
+		_ = ow.CloseWithError(err)
+		errs <- err
+	}()
+
+	if _, err := pw.Write([]byte("header\nb\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readWithin(t, output, 2), "b\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+	if _, err := pw.Write([]byte("c\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readWithin(t, output, 2), "c\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+
+	_ = pw.Close()
+	if err := <-errs; err != nil {
+		t.Errorf("GOT: %v; WANT: %v", err, nil)
+	}
+}
+
+func TestNumberedStages(t *testing.T) {
+	// Original line numbers survive a stage that writes to a numberedWriter,
+	// read by a lineScanner from a numberedReader.
+	var b bytes.Buffer
+	nw := numberedWriter{bufio.NewWriter(&b)}
+	err := linesel.Select(nw, newLineScanner(strings.NewReader("a\nb\nc\nd\n"), gobls.NewScanner(strings.NewReader("a\nb\nc\nd\n"))), linesel.Skip{Initial: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = nw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := numberedReader{&b}
+	ls := newLineScanner(r, gobls.NewScanner(r))
+	var got []string
+	for ls.Scan() {
+		got = append(got, strconv.Itoa(ls.LineNumber())+"="+string(ls.Bytes()))
+	}
+	if want := "3=c,4=d"; strings.Join(got, ",") != want {
+		t.Errorf("GOT: %q; WANT: %q", strings.Join(got, ","), want)
+	}
+}
+
+// BenchmarkOutput measures printing every line of an input of short lines,
+// and of an input of long lines, to the null device, through the buffered
+// output path, with and without line numbers, and by formatting each line
+// with fmt.Fprintln, as lines once did.
+func BenchmarkOutput(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	inputs := []struct {
+		name          string
+		length, count int
+	}{
+		{"short", 16, 64 * 1024},
+		{"long", 64 * 1024, 16},
+	}
+
+	writers := []struct {
+		name string
+		copy func(io.Reader) error
+	}{
+		{"buffered", func(r io.Reader) error {
+			out := bufio.NewWriter(devNull)
+			if err := linesel.Copy(out, r, linesel.Skip{}); err != nil {
+				return err
+			}
+			return out.Flush()
+		}},
+		{"numbered", func(r io.Reader) error {
+			out := bufio.NewWriter(devNull)
+			ow := outputWriter{Writer: out, number: true, width: 6, separator: "\t"}
+			if err := linesel.Copy(ow, r, linesel.Skip{}); err != nil {
+				return err
+			}
+			return out.Flush()
+		}},
+		{"fmt", func(r io.Reader) error {
+			br := gobls.NewScanner(r)
+			for br.Scan() {
+				if _, err := fmt.Fprintln(devNull, br.Text()); err != nil {
+					return err
+				}
+			}
+			return br.Err()
+		}},
+	}
+
+	for _, in := range inputs {
+		input := []byte(strings.Repeat(strings.Repeat("x", in.length-1)+"\n", in.count))
+		for _, w := range writers {
+			b.Run(in.name+"/"+w.name, func(b *testing.B) {
+				b.SetBytes(int64(len(input)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := w.copy(bytes.NewReader(input)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestWantsLineNumbers(t *testing.T) {
+	bw := bufio.NewWriter(io.Discard)
+	cases := []struct {
+		name string
+		w    io.Writer
+		want bool
+	}{
+		{"buffered", bw, false},
+		{"output", outputWriter{Writer: bw}, false},
+		{"output with filename", outputWriter{Writer: bw, filename: "a.log"}, false},
+		{"numbered output", outputWriter{Writer: bw, number: true}, true},
+		{"following stage", numberedWriter{bw}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := wantsLineNumbers(tc.w); got != tc.want {
+				t.Errorf("GOT: %v; WANT: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSelectLinesNumbersFinalLines(t *testing.T) {
+	// The final lines of a regular file are found by reading backwards from
+	// its end, unless their line numbers are printed, which requires reading
+	// the lines before them.
+	name := filepath.Join(writeFiles(t, map[string]string{"input": numberedInput(10)}), "input")
+
+	cases := []struct {
+		name string
+		ow   outputWriter
+		want string
+	}{
+		{"plain", outputWriter{}, "line 9\nline 10\n"},
+		{"numbered", outputWriter{number: true, width: 2, separator: " "}, " 9 line 9\n10 line 10\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fh, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+
+			var b bytes.Buffer
+			tc.ow.Writer = bufio.NewWriter(&b)
+			if err = selectLines(fh, tc.ow, linesel.Bottom(2)); err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if err = flushWriter(tc.ow); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// numberedInput returns n lines, each of which is "line" followed by its line
+// number.
+func numberedInput(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
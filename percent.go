@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/karrick/lines/linesel"
+)
+
+// parseAmount parses the number of lines given to the named option, as
+// described by linesel.ParseAmount.
+func parseAmount(name, s string) (linesel.Amount, error) {
+	a, err := linesel.ParseAmount(s)
+	if err != nil {
+		if errors.Is(err, linesel.ErrInvalidPercent) {
+			return a, NewErrUsage("cannot parse %s: %s", name, err)
+		}
+		return a, NewErrUsage("cannot parse %s: %q.", name, s)
+	}
+	return a, nil
+}
+
+// countedStage returns a stage that invokes callback with the total number of
+// lines in its input when counted is true, or with 0 when counted is false, so
+// that stages only pay the cost of counting lines when they need it.
+func countedStage(counted bool, callback func(r io.Reader, w io.Writer, total int) error) stage {
+	if !counted {
+		return func(r io.Reader, w io.Writer) error {
+			return callback(r, w, 0)
+		}
+	}
+	return func(r io.Reader, w io.Writer) error {
+		return withLineCount(r, func(r io.Reader, total int) error {
+			return callback(r, w, total)
+		})
+	}
+}
+
+// withLineCount invokes callback with a reader that provides the same lines as
+// r, along with the total number of lines r provides. A regular file is counted
+// by reading it once then seeking back to where it started. Any other input,
+// such as standard input or the preceding stage of a pipeline, is spooled to a
+// temporary file while it is counted.
+func withLineCount(r io.Reader, callback func(io.Reader, int) error) (err error) {
+	if fh, ok := r.(*os.File); ok {
+		if fi, err := fh.Stat(); err == nil && fi.Mode().IsRegular() {
+			if offset, err := fh.Seek(0, io.SeekCurrent); err == nil {
+				lc := newLineCounter()
+				if _, err = io.Copy(lc, fh); err != nil {
+					return err
+				}
+				if _, err = fh.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+				return callback(fh, lc.count())
+			}
+		}
+	}
+
+	var spool *os.File
+
+	spool, err = ioutil.TempFile("", ProgramName+"-")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err2 := spool.Close(); err == nil {
+			err = err2
+		}
+		if err2 := os.Remove(spool.Name()); err == nil {
+			err = err2
+		}
+	}()
+
+	lc := newLineCounter()
+	if _, err = io.Copy(io.MultiWriter(spool, lc), r); err != nil {
+		return err
+	}
+
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Preserve original line numbers when spooling lines from a preceding
+	// stage of a pipeline.
+	var sr io.Reader = spool
+	if _, ok := r.(numberedReader); ok {
+		sr = numberedReader{spool}
+	}
+
+	// Set err variable so deferred function can inspect it.
+	err = callback(sr, lc.count())
+	return
+}
+
+// lineCounter is an io.Writer that counts the lines written to it.
+type lineCounter struct {
+	newlines int
+	partial  bool // true when final byte written was not a newline
+
+	// When split is greater than 0, long lines are counted as one line for
+	// each split bytes, or part thereof, as scanned by a lineScanner.
+	split  int
+	length int  // bytes written since final newline
+	cr     bool // true when final byte written was a carriage return
+}
+
+// newLineCounter returns a lineCounter that counts lines as a lineScanner
+// would scan them.
+func newLineCounter() *lineCounter {
+	lc := new(lineCounter)
+	if *optLongLines == longLineSplit {
+		lc.split = int(*optMaxLineLength)
+	}
+	return lc
+}
+
+func (lc *lineCounter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	lc.partial = p[len(p)-1] != '\n'
+
+	if lc.split == 0 {
+		lc.newlines += bytes.Count(p, []byte{'\n'})
+		return len(p), nil
+	}
+
+	for b := p; len(b) > 0; {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lc.length += len(b)
+			lc.cr = b[len(b)-1] == '\r'
+			break
+		}
+		if i > 0 {
+			lc.length += i
+			lc.cr = b[i-1] == '\r'
+		}
+		lc.newlines += lc.pieces()
+		lc.length, lc.cr = 0, false
+		b = b[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// pieces returns the number of lines the bytes written since the final
+// newline are split into.
+func (lc *lineCounter) pieces() int {
+	length := lc.length
+	if lc.cr {
+		length-- // scanners do not return the carriage return before a newline
+	}
+	if length <= lc.split {
+		return 1
+	}
+	return (length + lc.split - 1) / lc.split
+}
+
+// count returns the number of lines written, including a final line that was
+// not terminated by a newline.
+func (lc *lineCounter) count() int {
+	if !lc.partial {
+		return lc.newlines
+	}
+	if lc.split == 0 {
+		return lc.newlines + 1
+	}
+	return lc.newlines + lc.pieces()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineCounter(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks []string
+		split  int
+		want   int
+	}{
+		{name: "empty", want: 0},
+		{name: "one line", chunks: []string{"a\n"}, want: 1},
+		{name: "final line without newline", chunks: []string{"a\nb"}, want: 2},
+		{name: "empty lines", chunks: []string{"\n\n\n"}, want: 3},
+		{name: "chunks", chunks: []string{"a", "b\nc", "\n", "d"}, want: 3},
+		{name: "split", chunks: []string{"abcdefghij\nxy\n"}, split: 4, want: 4},
+		{name: "split exact", chunks: []string{"abcdefgh\n"}, split: 4, want: 2},
+		{name: "split chunks", chunks: []string{"abc", "def", "ghij\nx", "y"}, split: 4, want: 4},
+		{name: "split empty line", chunks: []string{"\n"}, split: 4, want: 1},
+		{name: "split ignores carriage return", chunks: []string{"abcd\r\n"}, split: 4, want: 1},
+		{name: "split carriage return in chunk", chunks: []string{"abcd\r", "\n"}, split: 4, want: 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lc := &lineCounter{split: tc.split}
+			for _, chunk := range tc.chunks {
+				if _, err := lc.Write([]byte(chunk)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := lc.count(); got != tc.want {
+				t.Errorf("GOT: %d; WANT: %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithLineCountSpoolsInput(t *testing.T) {
+	const input = "a\nb\nc"
+
+	err := withLineCount(strings.NewReader(input), func(r io.Reader, total int) error {
+		if total != 3 {
+			t.Errorf("GOT: %d lines; WANT: %d", total, 3)
+		}
+		got, err := io.ReadAll(r)
+		if string(got) != input {
+			t.Errorf("GOT: %q; WANT: %q", got, input)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+}
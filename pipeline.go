@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+)
+
+// errStageDone is returned to a stage that attempts to write to the following
+// stage of a pipeline after the following stage has stopped reading, because
+// it has already printed all of the lines it will print.
+var errStageDone = errors.New("following stage is done")
+
+// runningStages tracks the goroutine of each stage of a pipeline other than its
+// final stage. A pipeline does not wait for its preceding stages once its final
+// stage has printed every line it will print, so anything that reads more of
+// the input of a pipeline after the pipeline returns, such as the following
+// member of an archive, must first wait for them, rather than read the input
+// while they may still be reading it.
+var runningStages sync.WaitGroup
+
+// stage is a single step of a selection pipeline, which copies the lines it
+// selects from r to w.
+type stage func(r io.Reader, w io.Writer) error
+
+// pipeline returns a stage that feeds the lines each of the provided stages
+// copy into the following stage, so the final stage copies to w only the lines
+// that every stage selected. Each stage other than the final stage runs in its
+// own goroutine, connected to the following stage by an io.Pipe that carries
+// the original line number of each line along with the line.
+func pipeline(stages ...stage) stage {
+	if len(stages) == 1 {
+		return stages[0]
+	}
+
+	return func(r io.Reader, w io.Writer) error {
+		errs := make(chan error, len(stages)-1)
+
+		for _, s := range stages[:len(stages)-1] {
+			pr, pw := io.Pipe()
+
+			runningStages.Add(1)
+			go func(s stage, r io.Reader, pw *io.PipeWriter) {
+				defer runningStages.Done()
+				// Buffer lines, so the following stage reads many lines at
+				// a time rather than one at a time.
+				bw := bufio.NewWriter(pw)
+				err := s(r, numberedWriter{bw})
+				if err == nil {
+					err = bw.Flush()
+				}
+				_ = pw.CloseWithError(err) // nil error causes reader to get io.EOF
+				closeStage(r)
+				errs <- err
+			}(s, r, pw)
+
+			r = numberedReader{pr}
+		}
+
+		err := stages[len(stages)-1](r, w)
+		closeStage(r)
+
+		// An error in a preceding stage is passed along to each following
+		// stage, so when the final stage succeeds, any preceding stage still
+		// running was stopped by its following stage, and will return once it
+		// next writes. Waiting for it here would delay printing the selected
+		// lines until more input arrives, which for a pipe may be never.
+		if err == nil {
+			return nil
+		}
+
+		// Wait for each preceding stage to complete, reporting the first error
+		// other than being stopped by the following stage.
+		for range stages[1:] {
+			if err2 := <-errs; err == nil && err2 != errStageDone {
+				err = err2
+			}
+		}
+
+		return err
+	}
+}
+
+// closeStage causes the preceding stage of a pipeline to stop writing to r,
+// when r is an io.PipeReader joining two stages.
+func closeStage(r io.Reader) {
+	if nr, ok := r.(numberedReader); ok {
+		if pr, ok := nr.Reader.(*io.PipeReader); ok {
+			_ = pr.CloseWithError(errStageDone)
+		}
+	}
+}
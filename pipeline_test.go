@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/karrick/lines/linesel"
+)
+
+// selectStage returns a stage that copies the lines sel selects.
+func selectStage(sel linesel.Selector) stage {
+	return func(r io.Reader, w io.Writer) error {
+		return selectLines(r, w, sel)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	cases := []struct {
+		name   string
+		stages []stage
+		want   string
+	}{
+		{"single stage", []stage{selectStage(linesel.Top(2))}, "1\n2\n"},
+		{"skip then top", []stage{selectStage(linesel.Skip{Initial: 1}), selectStage(linesel.Top(2))}, "2\n3\n"},
+		{"skip then bottom", []stage{selectStage(linesel.Skip{Initial: 1, Final: 1}), selectStage(linesel.Bottom(2))}, "3\n4\n"},
+		{"three stages", []stage{selectStage(linesel.Skip{Initial: 1}), selectStage(linesel.Bottom(3)), selectStage(linesel.Top(1))}, "3\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			bw := bufio.NewWriter(&out)
+			// A bytes.Buffer is not a regular file, so every stage takes the
+			// same path as for a pipe.
+			if err := pipeline(tc.stages...)(bytes.NewBufferString("1\n2\n3\n4\n5\n"), bw); err != nil {
+				t.Fatalf("GOT: %v; WANT: %v", err, nil)
+			}
+			if err := bw.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tc.want {
+				t.Errorf("GOT: %q; WANT: %q", got, tc.want)
+			}
+			runningStages.Wait()
+		})
+	}
+}
+
+func TestPipelineReturnsBeforeInputEnds(t *testing.T) {
+	// Once the final stage has printed its lines, the pipeline returns, even
+	// though the preceding stage is still waiting for more input.
+	pr, pw := io.Pipe()
+	go func() { _, _ = pw.Write([]byte("1\n2\n3\n4\n5\n")) }()
+
+	var out bytes.Buffer
+	bw := bufio.NewWriter(&out)
+	done := make(chan error, 1)
+	go func() {
+		done <- pipeline(selectStage(linesel.Skip{Initial: 1}), selectStage(linesel.Top(2)))(pr, bw)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GOT: %v; WANT: %v", err, nil)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GOT: pipeline waiting for input; WANT: pipeline returned")
+	}
+
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "2\n3\n"; got != want {
+		t.Errorf("GOT: %q; WANT: %q", got, want)
+	}
+
+	// Ending the input releases the preceding stage.
+	_ = pw.Close()
+	runningStages.Wait()
+}
+
+func TestPipelineReportsPrecedingStageError(t *testing.T) {
+	errRead := io.ErrUnexpectedEOF
+	input := io.MultiReader(bytes.NewBufferString("1\n2\n"), iotest.ErrReader(errRead))
+
+	err := pipeline(selectStage(linesel.Skip{Initial: 1}), selectStage(linesel.Bottom(5)))(input, bufio.NewWriter(io.Discard))
+	if err != errRead {
+		t.Errorf("GOT: %v; WANT: %v", err, errRead)
+	}
+	runningStages.Wait()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// tailBlockSize is the number of bytes read at a time while searching backwards
+// from the end of a file for the start of its final lines.
+const tailBlockSize = 64 * 1024
+
+// splitFinalLines returns a reader for the lines of r preceding its final n
+// lines, and a reader for its final n lines, when r is a regular file that may
+// be read from its end, without reading the lines before its final n lines.
+// Both readers are nil when r cannot be read from its end, in which case the
+// caller must read every line of r instead.
+func splitFinalLines(r io.Reader, n int) (head, tail *io.SectionReader, err error) {
+	fh, ok := r.(*os.File)
+	if !ok || *optMaxLineLength > 0 {
+		// Every line must be scanned to apply the --long-lines policy.
+		return nil, nil, nil
+	}
+	fi, err := fh.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, nil, nil
+	}
+	start, err := fh.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, nil
+	}
+	size := fi.Size()
+	if size < start {
+		size = start
+	}
+
+	offset, err := finalLinesOffset(fh, start, size, n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return io.NewSectionReader(fh, start, offset-start), io.NewSectionReader(fh, offset, size-offset), nil
+}
+
+// finalLinesOffset returns the offset of the first byte of the final n lines of
+// fh between start and size, or start when there are no more than n lines
+// between them. A final line that does not end with a newline is still a line.
+func finalLinesOffset(fh *os.File, start, size int64, n int) (int64, error) {
+	buf := make([]byte, tailBlockSize)
+	end := size
+	terminal := true // true until the final byte has been examined
+
+	for end > start {
+		from := end - int64(len(buf))
+		if from < start {
+			from = start
+		}
+		block := buf[:end-from]
+		if _, err := fh.ReadAt(block, from); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if terminal {
+			// The newline terminating the final line does not separate it
+			// from a following line.
+			if block[len(block)-1] == '\n' {
+				block = block[:len(block)-1]
+			}
+			terminal = false
+		}
+
+		for {
+			i := bytes.LastIndexByte(block, '\n')
+			if i < 0 {
+				break
+			}
+			if n--; n == 0 {
+				return from + int64(i) + 1, nil
+			}
+			block = block[:i]
+		}
+
+		end = from
+	}
+
+	return start, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// finalLinesOffsetOf returns the offset of the first byte of the final n lines
+// of contents, by splitting contents into its lines.
+func finalLinesOffsetOf(contents string, n int) int64 {
+	lines := strings.SplitAfter(contents, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if n >= len(lines) {
+		return 0
+	}
+	return int64(len(strings.Join(lines[:len(lines)-n], "")))
+}
+
+func TestSplitFinalLines(t *testing.T) {
+	long := strings.Repeat("x", tailBlockSize+100)
+
+	cases := []struct {
+		name     string
+		contents string
+	}{
+		{"empty", ""},
+		{"one line", "a\n"},
+		{"no final newline", "a\nb\nc"},
+		{"empty lines", "\n\n\n\n"},
+		{"short lines", "1\n2\n3\n4\n5\n"},
+		{"long lines", long + "\n" + long + "\n" + long + "\n"},
+		{"newline at block boundary", strings.Repeat("y", tailBlockSize-1) + "\n" + "a\nb\n"},
+		{"long final line", "a\nb\n" + long},
+		{"many short lines", strings.Repeat("line\n", 3*tailBlockSize/5)},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "input.txt")
+			if err := os.WriteFile(name, []byte(tc.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fh, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fh.Close()
+
+			for _, n := range []int{1, 2, 3, 10, 20000, 100000} {
+				head, tail, err := splitFinalLines(fh, n)
+				if err != nil {
+					t.Fatalf("GOT: %v; WANT: %v", err, nil)
+				}
+				if head == nil || tail == nil {
+					t.Fatalf("GOT: nil readers; WANT: readers of a regular file")
+				}
+				want := finalLinesOffsetOf(tc.contents, n)
+				if got := head.Size(); got != want {
+					t.Errorf("%d lines: GOT: offset %d; WANT: %d", n, got, want)
+				}
+				got, err := io.ReadAll(tail)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tc.contents[want:] {
+					t.Errorf("%d lines: GOT: %d bytes; WANT: %d", n, len(got), len(tc.contents[want:]))
+				}
+			}
+		})
+	}
+}
+
+func TestSplitFinalLinesFromOffset(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(name, []byte("1\n2\n3\n4\n5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	// Lines before the current offset, such as those read by a preceding
+	// stage, are not part of the input.
+	if _, err = fh.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	head, tail, err := splitFinalLines(fh, 10)
+	if err != nil {
+		t.Fatalf("GOT: %v; WANT: %v", err, nil)
+	}
+	got, _ := io.ReadAll(tail)
+	if head.Size() != 0 || string(got) != "4\n5\n" {
+		t.Errorf("GOT: %d, %q; WANT: %d, %q", head.Size(), got, 0, "4\n5\n")
+	}
+}
+
+func TestSplitFinalLinesRequiresRegularFile(t *testing.T) {
+	head, tail, err := splitFinalLines(strings.NewReader("1\n2\n"), 1)
+	if head != nil || tail != nil || err != nil {
+		t.Errorf("GOT: %v, %v, %v; WANT: nil readers", head, tail, err)
+	}
+}